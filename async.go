@@ -0,0 +1,178 @@
+package gcp
+
+import (
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	"cloud.google.com/go/pubsub"
+	"github.com/grafana/sobek"
+	"go.k6.io/k6/js/common"
+	"golang.org/x/oauth2"
+)
+
+// The function runs an operation outside of the event loop and returns a promise settled with its result
+// on the event loop, so that a VU can overlap several calls with `Promise.all`. Rejections are Go errors
+// like the exceptions of the blocking methods, e.g. `e.value.code` holds the code of a `GcpError`.
+func promise[T any](g *Gcp, f func() (T, error)) *sobek.Promise {
+	rt := g.vu.Runtime()
+	p, resolve, reject := rt.NewPromise()
+	callback := g.vu.RegisterCallback()
+
+	go func() {
+		v, err := f()
+		callback(func() error {
+			if err != nil {
+				reject(rt.NewGoError(err))
+				return nil
+			}
+
+			resolve(v)
+			return nil
+		})
+	}()
+
+	return p
+}
+
+// Asynchronous `SpreadsheetGet`
+func (g *Gcp) SpreadsheetGetAsync(spreadsheetId string, sheetName string, cellRange string, opts CallOptions) *sobek.Promise {
+	return promise(g, func() ([][]interface{}, error) {
+		return g.SpreadsheetGet(spreadsheetId, sheetName, cellRange, opts)
+	})
+}
+
+// Asynchronous `SpreadsheetAppend`
+func (g *Gcp) SpreadsheetAppendAsync(spreadsheetId string, sheetName string, valueRange []interface{}, opts CallOptions) *sobek.Promise {
+	return promise(g, func() (string, error) {
+		return g.SpreadsheetAppend(spreadsheetId, sheetName, valueRange, opts)
+	})
+}
+
+// Asynchronous `SpreadsheetUpdate`
+func (g *Gcp) SpreadsheetUpdateAsync(spreadsheetId string, sheetName string, cellRange string, valueRange []interface{}, opts CallOptions) *sobek.Promise {
+	return promise(g, func() (string, error) {
+		return g.SpreadsheetUpdate(spreadsheetId, sheetName, cellRange, valueRange, opts)
+	})
+}
+
+// Asynchronous `SpreadsheetGetRowByFilters`
+func (g *Gcp) SpreadsheetGetRowByFiltersAsync(spreadsheetId string, sheetName string, filters map[string]string, opts CallOptions) *sobek.Promise {
+	return promise(g, func() (map[string]interface{}, error) {
+		return g.SpreadsheetGetRowByFilters(spreadsheetId, sheetName, filters, opts)
+	})
+}
+
+// Asynchronous `SpreadsheetAppendWithUniqueId`
+func (g *Gcp) SpreadsheetAppendWithUniqueIdAsync(spreadsheetId string, sheetName string, values map[string]interface{}, opts CallOptions) *sobek.Promise {
+	return promise(g, func() (int64, error) {
+		return g.SpreadsheetAppendWithUniqueId(spreadsheetId, sheetName, values, opts)
+	})
+}
+
+// Asynchronous `SpreadsheetGetUniqueIdByFiltersAndAppendIfNotExist`
+func (g *Gcp) SpreadsheetGetUniqueIdByFiltersAndAppendIfNotExistAsync(spreadsheetId string, sheetName string, filters map[string]string, values map[string]interface{}, opts CallOptions) *sobek.Promise {
+	return promise(g, func() (int64, error) {
+		return g.SpreadsheetGetUniqueIdByFiltersAndAppendIfNotExist(spreadsheetId, sheetName, filters, values, opts)
+	})
+}
+
+// Asynchronous `PubsubPublish`
+func (g *Gcp) PubsubPublishAsync(t *pubsub.Topic, message map[string]interface{}, opts CallOptions) *sobek.Promise {
+	return promise(g, func() (string, error) {
+		return g.PubsubPublish(t, message, opts)
+	})
+}
+
+// Asynchronous `PubsubReceive`
+func (g *Gcp) PubsubReceiveAsync(s *pubsub.Subscription, limit int, timeout int, opts CallOptions) *sobek.Promise {
+	return promise(g, func() ([]map[string]interface{}, error) {
+		return g.PubsubReceive(s, limit, timeout, opts)
+	})
+}
+
+// Asynchronous `QueryTimeSeries`
+func (g *Gcp) QueryTimeSeriesAsync(projectId string, query string, opts CallOptions) *sobek.Promise {
+	return promise(g, func() ([]*monitoringpb.TimeSeriesData, error) {
+		return g.QueryTimeSeries(projectId, query, opts)
+	})
+}
+
+// Asynchronous `GetOAuth2AccessToken`
+func (g *Gcp) GetOAuth2AccessTokenAsync(scope []string, opts CallOptions) *sobek.Promise {
+	return promise(g, func() (*oauth2.Token, error) {
+		return g.GetOAuth2AccessToken(scope, opts)
+	})
+}
+
+// Asynchronous `GetOAuth2IdToken`
+func (g *Gcp) GetOAuth2IdTokenAsync(audience string, options IdTokenOptions) *sobek.Promise {
+	return promise(g, func() (*oauth2.Token, error) {
+		return g.GetOAuth2IdToken(audience, options)
+	})
+}
+
+// Asynchronous `DownscopedToken`
+func (g *Gcp) DownscopedTokenAsync(options DownscopedTokenOptions) *sobek.Promise {
+	return promise(g, func() (*oauth2.Token, error) {
+		return g.DownscopedToken(options)
+	})
+}
+
+// Asynchronous `AuthHeaders`
+func (g *Gcp) AuthHeadersAsync(options AuthOptions) *sobek.Promise {
+	return promise(g, func() (map[string]string, error) {
+		return g.AuthHeaders(options)
+	})
+}
+
+// Asynchronous `SignJwt`
+func (g *Gcp) SignJwtAsync(claims map[string]interface{}, options SignJwtOptions) *sobek.Promise {
+	return promise(g, func() (string, error) {
+		return g.SignJwt(claims, options)
+	})
+}
+
+// Asynchronous `SignBlob`. The data is copied first, since an `ArrayBuffer` may change once the call
+// returns.
+func (g *Gcp) SignBlobAsync(data interface{}, opts CallOptions) *sobek.Promise {
+	if b, err := common.ToBytes(data); err == nil {
+		data = append([]byte(nil), b...)
+	}
+
+	return promise(g, func() (string, error) {
+		return g.SignBlob(data, opts)
+	})
+}
+
+// Asynchronous `VerifyIdToken`
+func (g *Gcp) VerifyIdTokenAsync(token string, options VerifyIdTokenOptions) *sobek.Promise {
+	return promise(g, func() (map[string]interface{}, error) {
+		return g.VerifyIdToken(token, options)
+	})
+}
+
+// Asynchronous `SignInWithCustomToken`
+func (f *GcpFirebase) SignInWithCustomTokenAsync(token string) *sobek.Promise {
+	return promise(f.g, func() (*FirebaseSession, error) {
+		return f.SignInWithCustomToken(token)
+	})
+}
+
+// Asynchronous `ProvisionUsers`
+func (i *GcpIdentity) ProvisionUsersAsync(count int, template IdentityUserTemplate) *sobek.Promise {
+	return promise(i.g, func() ([]string, error) {
+		return i.ProvisionUsers(count, template)
+	})
+}
+
+// Asynchronous `UserFor`
+func (i *GcpIdentity) UserForAsync(vu int) *sobek.Promise {
+	return promise(i.g, func() (*IdentityUser, error) {
+		return i.UserFor(vu)
+	})
+}
+
+// Asynchronous `DeleteUsers`
+func (i *GcpIdentity) DeleteUsersAsync() *sobek.Promise {
+	return promise(i.g, func() (int, error) {
+		return i.DeleteUsers()
+	})
+}
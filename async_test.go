@@ -0,0 +1,60 @@
+package gcp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAsyncMethodsSettlePromises(t *testing.T) {
+	sheets := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v4/spreadsheets/sheet-id/values/Users!A:A" {
+			http.Error(w, `{"error":{"code":404,"message":"not found"}}`, http.StatusNotFound)
+			return
+		}
+
+		writeValues(t, w, [][]interface{}{{"id"}, {"1"}})
+	}))
+	t.Cleanup(sheets.Close)
+
+	tests := []struct {
+		name          string
+		spreadsheetId string
+		expected      string
+	}{
+		{"resolved", "sheet-id", "resolved id,1"},
+		{"rejected", "missing", "rejected sheets spreadsheetGet NOT_FOUND 404 false"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rt, mi, _ := newTestVu(t, New(), 1)
+			tokens, _ := tokenServer(t)
+			g, err := mi.gcpFromConfig(GcpConfig{
+				Key:           testServiceAccountKey(t, tokens.URL),
+				ClientOptions: GcpClientOptions{Services: map[string]GcpClientOptions{sheetsService: {Endpoint: sheets.URL + "/"}}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := rt.VU.Runtime().Set("gcp", g); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = rt.RunOnEventLoop(`
+				var result;
+				gcp.spreadsheetGetAsync('` + tt.spreadsheetId + `', 'Users', 'A:A', {}).then(
+					(rows) => { result = 'resolved ' + rows.join() },
+					(e) => { result = ['rejected', e.value.service, e.value.operation, e.value.code, e.value.http_status, e.value.retryable].join(' ') },
+				)
+			`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if result := rt.VU.Runtime().Get("result").String(); result != tt.expected {
+				t.Errorf("expected the promise to be %s, got %s", tt.expected, result)
+			}
+		})
+	}
+}
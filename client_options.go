@@ -0,0 +1,166 @@
+package gcp
+
+import (
+	"fmt"
+	"net/http"
+
+	"google.golang.org/api/option"
+)
+
+// Services whose clients accept `GcpClientOptions.Services` overrides
+var clientOptionsServices = map[string]bool{
+	sheetsService:         true,
+	pubsubService:         true,
+	monitoringService:     true,
+	iamCredentialsService: true,
+}
+
+// Options of the clients of the Google APIs, e.g. to reach regional endpoints or Private Service Connect
+// hostnames, or to bill quota to another project
+type GcpClientOptions struct {
+	// API endpoint, e.g. `us-east1-pubsub.googleapis.com:443`
+	Endpoint string
+	// Universe domain of the APIs, defaults to the one of the credentials
+	UniverseDomain string
+	// Project billed for quota, sent as `x-goog-user-project`
+	QuotaProject string
+	// Added to the user agent of the requests
+	UserAgent string
+	// Number of gRPC connections of gRPC clients
+	GrpcConnPoolSize int
+	// Options of a service, `sheets`, `pubsub`, `monitoring` or `iamcredentials`, overriding the ones above
+	Services map[string]GcpClientOptions
+}
+
+func withGcpConstructorClientOptions(options GcpClientOptions) func(*Gcp) error {
+	return func(g *Gcp) error {
+		if err := options.validate(); err != nil {
+			return err
+		}
+		for service, o := range options.Services {
+			if !clientOptionsServices[service] {
+				return fmt.Errorf("unknown client_options service %s, expected %s, %s, %s or %s", service, sheetsService, pubsubService, monitoringService, iamCredentialsService)
+			}
+			if len(o.Services) != 0 {
+				return fmt.Errorf("client_options of service %s cannot declare nested services", service)
+			}
+			if err := o.validate(); err != nil {
+				return fmt.Errorf("invalid client_options of service %s <%w>", service, err)
+			}
+		}
+
+		if options.UniverseDomain == "" {
+			options.UniverseDomain = g.universeDomain()
+		}
+		g.clientOptions = options
+
+		return nil
+	}
+}
+
+// This is a method of the `Gcp` struct that returns the universe domain of its credentials: the one of
+// the credentials JSON, else the one of Application Default Credentials, e.g. read from the metadata
+// server. It is empty for the default universe or when unknown.
+func (g *Gcp) universeDomain() string {
+	if g.key != nil {
+		return g.key.universeDomain()
+	}
+	if g.credentials == nil {
+		return ""
+	}
+
+	ud, err := g.credentials.GetUniverseDomain()
+	if err != nil {
+		return ""
+	}
+
+	return ud
+}
+
+// This is a method of the `Gcp` struct that returns the client options of a service, where the options
+// of the service override the global ones.
+func (g *Gcp) serviceClientOptions(service string) GcpClientOptions {
+	o := mergeClientOptions(g.clientOptions, g.clientOptions.Services[service])
+	o.Services = nil
+
+	return o
+}
+
+// The function returns the client options of the API clients. The user agent is left to the callers,
+// since REST clients add it to their own user agent while gRPC clients take it as an option.
+func (o GcpClientOptions) options() []option.ClientOption {
+	var options []option.ClientOption
+	if o.Endpoint != "" {
+		options = append(options, option.WithEndpoint(o.Endpoint))
+	}
+	if o.UniverseDomain != "" {
+		options = append(options, option.WithUniverseDomain(o.UniverseDomain))
+	}
+	if o.QuotaProject != "" {
+		options = append(options, option.WithQuotaProject(o.QuotaProject))
+	}
+	if o.GrpcConnPoolSize > 0 {
+		options = append(options, option.WithGRPCConnectionPool(o.GrpcConnPoolSize))
+	}
+
+	return options
+}
+
+// The function returns the client options of REST clients sending their requests with an HTTP client built
+// from the options. The HTTP client sends the quota project, which the clients reject along with it.
+func (o GcpClientOptions) restOptions(hc *http.Client) []option.ClientOption {
+	o.QuotaProject = ""
+
+	return append(o.options(), option.WithHTTPClient(hc))
+}
+
+// The function returns the client options of gRPC clients, including the user agent.
+func (o GcpClientOptions) grpcOptions() []option.ClientOption {
+	options := o.options()
+	if o.UserAgent != "" {
+		options = append(options, option.WithUserAgent(o.UserAgent))
+	}
+
+	return options
+}
+
+func (o GcpClientOptions) validate() error {
+	if o.GrpcConnPoolSize < 0 {
+		return fmt.Errorf("grpc_conn_pool_size must be a positive number of connections, got %d", o.GrpcConnPoolSize)
+	}
+
+	return nil
+}
+
+// The function returns the client options where every field set in the override replaces the base one.
+// Service options are merged per service.
+func mergeClientOptions(base GcpClientOptions, override GcpClientOptions) GcpClientOptions {
+	o := base
+
+	if override.Endpoint != "" {
+		o.Endpoint = override.Endpoint
+	}
+	if override.UniverseDomain != "" {
+		o.UniverseDomain = override.UniverseDomain
+	}
+	if override.QuotaProject != "" {
+		o.QuotaProject = override.QuotaProject
+	}
+	if override.UserAgent != "" {
+		o.UserAgent = override.UserAgent
+	}
+	if override.GrpcConnPoolSize != 0 {
+		o.GrpcConnPoolSize = override.GrpcConnPoolSize
+	}
+	if len(override.Services) != 0 {
+		o.Services = make(map[string]GcpClientOptions, len(base.Services)+len(override.Services))
+		for service, s := range base.Services {
+			o.Services[service] = s
+		}
+		for service, s := range override.Services {
+			o.Services[service] = mergeClientOptions(base.Services[service], s)
+		}
+	}
+
+	return o
+}
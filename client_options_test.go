@@ -0,0 +1,75 @@
+package gcp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestServiceClientOptions(t *testing.T) {
+	g := newTestGcp(t, withGcpConstructorClientOptions(GcpClientOptions{
+		Endpoint:         "global:443",
+		QuotaProject:     "global-quota",
+		UserAgent:        "global-agent",
+		GrpcConnPoolSize: 2,
+		Services: map[string]GcpClientOptions{
+			sheetsService: {Endpoint: "sheets:443", UserAgent: "sheets-agent"},
+			pubsubService: {QuotaProject: "pubsub-quota", GrpcConnPoolSize: 4},
+		},
+	}))
+
+	tests := []struct {
+		service  string
+		expected GcpClientOptions
+	}{
+		{sheetsService, GcpClientOptions{Endpoint: "sheets:443", QuotaProject: "global-quota", UserAgent: "sheets-agent", GrpcConnPoolSize: 2}},
+		{pubsubService, GcpClientOptions{Endpoint: "global:443", QuotaProject: "pubsub-quota", UserAgent: "global-agent", GrpcConnPoolSize: 4}},
+		{monitoringService, GcpClientOptions{Endpoint: "global:443", QuotaProject: "global-quota", UserAgent: "global-agent", GrpcConnPoolSize: 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.service, func(t *testing.T) {
+			if o := g.serviceClientOptions(tt.service); !reflect.DeepEqual(o, tt.expected) {
+				t.Errorf("expected options %+v, got %+v", tt.expected, o)
+			}
+		})
+	}
+}
+
+func TestSheetsClientUsesServiceOptions(t *testing.T) {
+	global := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("expected the endpoint of the service to take precedence, got a request to %s", r.URL)
+		http.NotFound(w, r)
+	}))
+	t.Cleanup(global.Close)
+
+	var header http.Header
+	sheets := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		writeValues(t, w, [][]interface{}{{"id"}})
+	}))
+	t.Cleanup(sheets.Close)
+
+	tokens, _ := tokenServer(t)
+	g := newTestGcp(t,
+		withGcpConstructorKey(testServiceAccountKey(t, tokens.URL), ""),
+		withGcpConstructorClientOptions(GcpClientOptions{
+			Endpoint:     global.URL + "/",
+			QuotaProject: "global-quota",
+			UserAgent:    "global-agent",
+			Services:     map[string]GcpClientOptions{sheetsService: {Endpoint: sheets.URL + "/", UserAgent: "sheets-agent"}},
+		}),
+	)
+
+	if _, err := g.SpreadsheetGet("sheet-id", "Users", "A:A", CallOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if ua := header.Get("User-Agent"); !strings.Contains(ua, "sheets-agent") || strings.Contains(ua, "global-agent") {
+		t.Errorf("expected the user agent of the service, got %s", ua)
+	}
+	if q := header.Get("X-Goog-User-Project"); q != "global-quota" {
+		t.Errorf("expected the global quota project, got %s", q)
+	}
+}
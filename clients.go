@@ -0,0 +1,343 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+)
+
+const (
+	// Every `Gcp` instance creates its own clients
+	clientSharingVu = "vu"
+	// `Gcp` instances of every VU with the same credentials and client options share their clients
+	clientSharingProcess = "process"
+)
+
+type (
+	// Process-wide pool of the clients shared by the `Gcp` instances of every VU, keyed by service,
+	// credentials and endpoint. A client is closed once the last instance using it released it.
+	clientPool struct {
+		mu      sync.Mutex
+		clients map[string]*pooledClient
+	}
+
+	// A client of the pool, usable once ready is closed. Clients are created and closed outside of the
+	// pool lock, so that a slow client only holds up the instances waiting for it.
+	pooledClient struct {
+		ready  chan struct{}
+		client interface{}
+		err    error
+		close  func() error
+		cancel context.CancelFunc
+		refs   int
+	}
+
+	// The function creates a client bound to a context, along with the function closing it when the client
+	// holds connections.
+	poolFactory func(ctx context.Context) (interface{}, func() error, error)
+
+	// The function creates the client of a service for an instance, bound to a context.
+	clientFactory func(g *Gcp, ctx context.Context) (interface{}, func() error, error)
+
+	// Credentials of shared clients, authorizing each call with the token source of the instance making
+	// it, so that tokens are fetched and their cache metrics emitted for the calling VU. Calls the client
+	// makes in the background, e.g. batched publishes, use the token source of the pool. As REST
+	// transport, it authorizes the requests sent with the base transport.
+	sharedCredentials struct {
+		owner        *Gcp
+		quotaProject string
+		base         http.RoundTripper
+	}
+)
+
+func newClientPool() *clientPool {
+	return &clientPool{
+		clients: make(map[string]*pooledClient),
+	}
+}
+
+// The function returns the client of a key, creating it on first use, and takes a reference on it.
+// Instances acquiring a client being created wait for it. Shared clients outlive the instance creating
+// them, so they are bound to a context of the pool, cancelled once the client is closed.
+func (p *clientPool) acquire(key string, create poolFactory) (interface{}, error) {
+	p.mu.Lock()
+	c, ok := p.clients[key]
+	if ok {
+		c.refs++
+		p.mu.Unlock()
+
+		<-c.ready
+		return c.client, c.err
+	}
+
+	c = &pooledClient{ready: make(chan struct{}), refs: 1}
+	p.clients[key] = c
+	p.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.client, c.close, c.err = create(ctx)
+	if c.err != nil {
+		cancel()
+
+		// Failures are not cached, the next instance tries again
+		p.mu.Lock()
+		if p.clients[key] == c {
+			delete(p.clients, key)
+		}
+		p.mu.Unlock()
+	} else {
+		c.cancel = cancel
+	}
+	close(c.ready)
+
+	return c.client, c.err
+}
+
+// The function drops a reference on the client of a key and closes it once it is no longer used.
+func (p *clientPool) release(key string) error {
+	p.mu.Lock()
+	c, ok := p.clients[key]
+	if !ok {
+		p.mu.Unlock()
+		return nil
+	}
+
+	if c.refs--; c.refs > 0 {
+		p.mu.Unlock()
+		return nil
+	}
+	delete(p.clients, key)
+	p.mu.Unlock()
+
+	<-c.ready
+	if c.cancel != nil {
+		defer c.cancel()
+	}
+	if c.close == nil {
+		return nil
+	}
+
+	return c.close()
+}
+
+// This is a method of the `Gcp` struct that returns a new client of a service, or the client shared by
+// every VU when clients are shared by the process. The client is closed, or released, once the lifetime
+// context ends. It must be called with the clients lock held.
+func (g *Gcp) acquireClient(service string, create clientFactory) (interface{}, error) {
+	if err := g.checkLifetime(); err != nil {
+		return nil, err
+	}
+
+	if g.clientSharing != clientSharingProcess {
+		client, closeClient, err := create(g, g.lifetime)
+		if err != nil {
+			return nil, err
+		}
+		if closeClient != nil {
+			g.closers = append(g.closers, closeClient)
+		}
+
+		return client, nil
+	}
+
+	key := g.clientKey(service)
+	client, err := g.clients.acquire(key, func(ctx context.Context) (interface{}, func() error, error) {
+		owner := g.poolInstance(ctx)
+
+		client, closeClient, err := create(owner, ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return client, func() error {
+			defer owner.transport.CloseIdleConnections()
+
+			if closeClient == nil {
+				return nil
+			}
+
+			return closeClient()
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	g.closers = append(g.closers, func() error {
+		return g.clients.release(key)
+	})
+
+	return client, nil
+}
+
+// This is a method of the `Gcp` struct that returns the instance a shared client is created with: it has
+// the configuration of the instance but belongs to the pool, with its own token sources fetching tokens
+// with the context of the pool. Its calls go through the network stack of the calling VU, see
+// `vuTransport`.
+func (g *Gcp) poolInstance(ctx context.Context) *Gcp {
+	p := &Gcp{
+		tokens:                    g.tokens,
+		jwks:                      g.jwks,
+		identities:                g.identities,
+		metrics:                   g.metrics,
+		emulatorHost:              g.emulatorHost,
+		keyByte:                   g.keyByte,
+		key:                       g.key,
+		scope:                     g.scope,
+		projectId:                 g.projectId,
+		apiKey:                    g.apiKey,
+		credentials:               g.credentials,
+		emulators:                 g.emulators,
+		clientOptions:             g.clientOptions,
+		retry:                     g.retry,
+		proxy:                     g.proxy,
+		iterations:                &iterationTrace{},
+		lifetime:                  ctx,
+		impersonateServiceAccount: g.impersonateServiceAccount,
+		delegates:                 g.delegates,
+		tokenSources:              newTokenSourceRegistry(),
+		clientSharing:             g.clientSharing,
+		clients:                   g.clients,
+		shared:                    true,
+	}
+	p.transport = &vuTransport{g: p}
+
+	return p
+}
+
+// This is a method of the `Gcp` struct that returns the key its client of a service is shared under.
+// Clients are only shared by instances with the same credentials, project, emulator, proxy and client
+// options.
+func (g *Gcp) clientKey(service string) string {
+	proxy := ""
+	if g.proxy != nil {
+		proxy = g.proxy.String()
+	}
+
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s|%+v", service, g.credentialsIdentity(), scopeKey(g.scope), g.projectId, g.emulators[service], proxy, g.serviceClientOptions(service))
+}
+
+// This is a method of the `Gcp` struct that returns the client options authorizing the gRPC or REST client
+// of a service with its scopes. Shared clients authorize each call with the instance making it, gRPC
+// clients through their per-RPC credentials and REST clients through `authorizeTransport`.
+func (g *Gcp) authOptions(service string, grpcClient bool) ([]option.ClientOption, error) {
+	if !g.shared {
+		ts, err := g.tokenSource(g.scope)
+		if err != nil {
+			return nil, fmt.Errorf("could not get token source with scope %s <%w>", g.scope, err)
+		}
+
+		return []option.ClientOption{option.WithTokenSource(ts)}, nil
+	}
+
+	if g.credentials == nil {
+		return nil, fmt.Errorf("no credentials configured for scope %s", g.scope)
+	}
+
+	if !grpcClient {
+		return []option.ClientOption{option.WithoutAuthentication()}, nil
+	}
+
+	return []option.ClientOption{
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithPerRPCCredentials(g.sharedCredentials(service, nil))),
+	}, nil
+}
+
+// This is a method of the `Gcp` struct that returns the transport of a REST client of a service, which
+// authorizes the calls of shared clients. Other clients are authorized by their client options.
+func (g *Gcp) authorizeTransport(service string, base http.RoundTripper) http.RoundTripper {
+	if !g.shared {
+		return base
+	}
+
+	return g.sharedCredentials(service, base)
+}
+
+// This is a method of the `Gcp` struct that returns the credentials of its shared client of a service.
+// Without authentication the API clients leave out the quota project, which is set like they do: from the
+// client options, then from GOOGLE_CLOUD_QUOTA_PROJECT.
+func (g *Gcp) sharedCredentials(service string, base http.RoundTripper) sharedCredentials {
+	quotaProject := g.serviceClientOptions(service).QuotaProject
+	if quotaProject == "" {
+		quotaProject = os.Getenv("GOOGLE_CLOUD_QUOTA_PROJECT")
+	}
+
+	return sharedCredentials{owner: g, quotaProject: quotaProject, base: base}
+}
+
+// The function returns the token of the instance making the call of a context, or of the pool for calls
+// made in the background.
+func (c sharedCredentials) token(ctx context.Context) (*oauth2.Token, error) {
+	g := c.owner
+	if r := requestFromContext(ctx); r != nil && r.caller().credentialsIdentity() == g.credentialsIdentity() {
+		g = r.caller()
+	}
+
+	ts, err := g.tokenSource(c.owner.scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokenWithContext(ctx, ts)
+}
+
+func (c sharedCredentials) GetRequestMetadata(ctx context.Context, _ ...string) (map[string]string, error) {
+	token, err := c.token(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	md := map[string]string{"authorization": token.Type() + " " + token.AccessToken}
+	if c.quotaProject != "" {
+		md["x-goog-user-project"] = c.quotaProject
+	}
+
+	return md, nil
+}
+
+func (sharedCredentials) RequireTransportSecurity() bool {
+	return true
+}
+
+func (c sharedCredentials) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := c.token(req.Context())
+	if err != nil {
+		// Round trippers close the body of the requests they fail
+		if req.Body != nil {
+			req.Body.Close()
+		}
+
+		return nil, err
+	}
+
+	r := req.Clone(req.Context())
+	token.SetAuthHeader(r)
+	if c.quotaProject != "" {
+		r.Header.Set("X-Goog-User-Project", c.quotaProject)
+	}
+
+	return c.base.RoundTrip(r)
+}
+
+func withGcpConstructorClientSharing(mi *ModuleInstance, sharing string) func(*Gcp) error {
+	return func(g *Gcp) error {
+		switch sharing {
+		case "":
+			sharing = clientSharingVu
+		case clientSharingVu, clientSharingProcess:
+		default:
+			return fmt.Errorf("unknown client_sharing %s, expected %s or %s", sharing, clientSharingVu, clientSharingProcess)
+		}
+
+		g.clientSharing = sharing
+		g.clients = mi.root.clients
+
+		return nil
+	}
+}
@@ -0,0 +1,196 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestClientPoolReferences(t *testing.T) {
+	p := newClientPool()
+
+	var created, closed int
+	contexts := make(map[int]context.Context)
+	create := func(ctx context.Context) (interface{}, func() error, error) {
+		created++
+		contexts[created] = ctx
+
+		return created, func() error {
+			closed++
+			return nil
+		}, nil
+	}
+
+	a, _ := p.acquire("a", create)
+	again, _ := p.acquire("a", create)
+	b, _ := p.acquire("b", create)
+	if a != 1 || again != 1 || b != 2 {
+		t.Fatalf("expected key a to share client 1 and key b to get client 2, got %v, %v and %v", a, again, b)
+	}
+
+	if err := p.release("a"); err != nil {
+		t.Fatal(err)
+	}
+	if closed != 0 || contexts[1].Err() != nil {
+		t.Fatal("expected the client to stay open while referenced")
+	}
+
+	if err := p.release("a"); err != nil {
+		t.Fatal(err)
+	}
+	if closed != 1 || contexts[1].Err() == nil {
+		t.Fatalf("expected the client to be closed after the last release, closed %d clients", closed)
+	}
+	if err := p.release("a"); err != nil || closed != 1 {
+		t.Fatalf("expected releasing a closed client to do nothing, got %v and %d closed clients", err, closed)
+	}
+
+	if c, _ := p.acquire("a", create); c != 3 {
+		t.Errorf("expected a new client once the last one was closed, got %v", c)
+	}
+
+	failing := func(ctx context.Context) (interface{}, func() error, error) {
+		created++
+		return nil, nil, errors.New("boom")
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := p.acquire("c", failing); err == nil {
+			t.Fatal("expected the creation of the client to fail")
+		}
+	}
+	if created != 5 {
+		t.Errorf("expected failed clients to be created again, got %d creations", created)
+	}
+}
+
+func TestClientKey(t *testing.T) {
+	tokens, _ := tokenServer(t)
+	key := testServiceAccountKey(t, tokens.URL)
+	other := testServiceAccountKey(t, tokens.URL)
+
+	base := newTestGcp(t, withGcpConstructorKey(key, ""), withGcpConstructorProjectId("p")).clientKey(pubsubService)
+
+	tests := []struct {
+		name   string
+		opts   []Option
+		shared bool
+	}{
+		{"same configuration", []Option{withGcpConstructorKey(key, ""), withGcpConstructorProjectId("p")}, true},
+		{"other credentials", []Option{withGcpConstructorKey(other, ""), withGcpConstructorProjectId("p")}, false},
+		{"other project", []Option{withGcpConstructorKey(key, ""), withGcpConstructorProjectId("q")}, false},
+		{"emulator", []Option{withGcpConstructorEmulators("localhost:8085", nil), withGcpConstructorKey(key, ""), withGcpConstructorProjectId("p")}, false},
+		{"emulator of another service", []Option{withGcpConstructorEmulators("", map[string]string{emulatorAuth: "localhost:9099"}), withGcpConstructorKey(key, ""), withGcpConstructorProjectId("p")}, true},
+		{"client options", []Option{withGcpConstructorKey(key, ""), withGcpConstructorProjectId("p"), withGcpConstructorClientOptions(GcpClientOptions{QuotaProject: "q"})}, false},
+		{"client options of another service", []Option{withGcpConstructorKey(key, ""), withGcpConstructorProjectId("p"), withGcpConstructorClientOptions(GcpClientOptions{Services: map[string]GcpClientOptions{sheetsService: {QuotaProject: "q"}}})}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if k := newTestGcp(t, tt.opts...).clientKey(pubsubService); (k == base) != tt.shared {
+				t.Errorf("expected the client to be shared: %t, got key %s for %s", tt.shared, k, base)
+			}
+		})
+	}
+}
+
+func TestSharedClientCallsWithCallingVu(t *testing.T) {
+	var authorizations []string
+	sheets := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorizations = append(authorizations, r.Header.Get("Authorization"))
+		writeValues(t, w, [][]interface{}{{"id"}})
+	}))
+	t.Cleanup(sheets.Close)
+
+	tokens, minted := tokenServer(t)
+	config := GcpConfig{
+		Key:           testServiceAccountKey(t, tokens.URL),
+		ClientSharing: clientSharingProcess,
+		ClientOptions: GcpClientOptions{Services: map[string]GcpClientOptions{sheetsService: {Endpoint: sheets.URL + "/"}}},
+	}
+
+	root := New()
+	var clients []interface{}
+	for vu := uint64(1); vu <= 2; vu++ {
+		_, mi, samples := newTestVu(t, root, vu)
+		dialer := &recordingDialer{}
+		mi.vu.State().Dialer = dialer
+
+		g, err := mi.gcpFromConfig(config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := g.SpreadsheetGet("sheet-id", "Users", "A:A", CallOptions{}); err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, g.sheet)
+
+		if dialer.dials.Load() == 0 {
+			t.Errorf("expected the call of VU %d to go through its network stack", vu)
+		}
+
+		// The first VU fetches the token, the second one finds it in the cache
+		expected := "gcp_token_cache_misses"
+		if vu == 2 {
+			expected = "gcp_token_cache_hits"
+		}
+		var lookups []string
+		for _, s := range collectSamples(samples) {
+			if tokenType, _ := s.Tags.Get("token_type"); tokenType == "access_token" {
+				lookups = append(lookups, s.Metric.Name)
+			}
+		}
+		if len(lookups) != 1 || lookups[0] != expected {
+			t.Errorf("expected VU %d to report a token lookup of %s, got %v", vu, expected, lookups)
+		}
+	}
+
+	if clients[0] != clients[1] {
+		t.Error("expected the VUs to share their Sheets client")
+	}
+	if n := minted.Load(); n != 1 {
+		t.Errorf("expected one token to be minted, got %d", n)
+	}
+	for _, a := range authorizations {
+		if a != "Bearer token-1" {
+			t.Errorf("expected calls to be authorized with the token of the VU, got %s", a)
+		}
+	}
+}
+
+// Dialer of a VU counting its dials
+type recordingDialer struct {
+	net.Dialer
+	dials atomic.Int32
+}
+
+func (d *recordingDialer) DialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	d.dials.Add(1)
+	return d.Dialer.DialContext(ctx, network, addr)
+}
+
+func TestSharedCredentialsRequestMetadata(t *testing.T) {
+	tokens, _ := tokenServer(t)
+	key := testServiceAccountKey(t, tokens.URL)
+	caller := newTestGcp(t, withGcpConstructorKey(key, ""), withGcpConstructorClientOptions(GcpClientOptions{QuotaProject: "q"}))
+	owner := caller.poolInstance(context.Background())
+
+	r := caller.startRequest(pubsubService, "publish", "")
+	if err := r.bind(caller, GcpRetryPolicy{}); err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := owner.sharedCredentials(pubsubService, nil).GetRequestMetadata(r.withContext(context.Background()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md["authorization"] != "Bearer token-1" || md["x-goog-user-project"] != "q" {
+		t.Errorf("expected the token of the caller and the quota project, got %v", md)
+	}
+	if len(caller.tokenSources.sources) != 1 || len(owner.tokenSources.sources) != 0 {
+		t.Error("expected the token to be looked up through the token sources of the caller")
+	}
+}
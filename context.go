@@ -0,0 +1,119 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// This is a method of the `Gcp` struct that returns the current context of the VU, which k6 cancels when
+// the iteration, `setup` or `teardown` ends or the test is aborted.
+func (g *Gcp) vuContext() context.Context {
+	if g.vu == nil || g.vu.Context() == nil {
+		return context.Background()
+	}
+
+	return g.vu.Context()
+}
+
+// This is a method of the `Gcp` struct that returns the context of a call, derived from the VU context and
+// bounded by the timeout of the call when given, e.g. `10s`.
+func (g *Gcp) callContext(timeout string) (context.Context, context.CancelFunc, error) {
+	if timeout == "" {
+		ctx, cancel := context.WithCancel(g.vuContext())
+		return ctx, cancel, nil
+	}
+
+	d, err := time.ParseDuration(timeout)
+	if err != nil {
+		return nil, nil, withCode("INVALID_ARGUMENT", fmt.Errorf("invalid timeout %s <%w>", timeout, err))
+	}
+
+	ctx, cancel := context.WithTimeout(g.vuContext(), d)
+	return ctx, cancel, nil
+}
+
+// This is a method of the `Gcp` struct that closes its clients once the context the VU was initialized
+// with ends, i.e. when the test finishes or is aborted.
+func (g *Gcp) closeClientsOnDone() {
+	done := g.lifetime.Done()
+	if done == nil {
+		// The context never ends, e.g. when k6 only reads the options of the script
+		return
+	}
+
+	go func() {
+		<-done
+		g.closeClients()
+	}()
+}
+
+func (g *Gcp) closeClients() {
+	// The iteration span ends before its tracer provider is released, so that it gets exported
+	g.iterations.end()
+
+	g.tracingMu.Lock()
+	if g.tracerRelease != nil {
+		_ = g.tracerRelease()
+	}
+	g.tracerProvider = nil
+	g.tracerRelease = nil
+	g.tracingMu.Unlock()
+
+	g.clientsMu.Lock()
+	defer g.clientsMu.Unlock()
+
+	// Topics flush the messages being published before their client closes
+	for _, t := range g.topics {
+		t.Stop()
+	}
+
+	for _, c := range g.closers {
+		_ = c()
+	}
+
+	g.closers = nil
+	g.sheet = nil
+	g.pubsub = nil
+	g.monitoring = nil
+	g.firebase = nil
+	g.identityAdmin = nil
+	g.topics = nil
+	g.subscriptions = nil
+
+	g.transport.CloseIdleConnections()
+}
+
+// This is a method of the `Gcp` struct that returns an error once clients can no longer be created,
+// because the VU ended. It must be called with the clients lock held.
+func (g *Gcp) checkLifetime() error {
+	if err := g.lifetime.Err(); err != nil {
+		return fmt.Errorf("clients cannot be initialized after the VU ended <%w>", err)
+	}
+
+	return nil
+}
+
+// The function waits for a token until the context of the call is done. Token sources are bound to the
+// lifetime of the VU instead, so a fetch outliving the call still fills the cache for the next one.
+func tokenWithContext(ctx context.Context, ts oauth2.TokenSource) (*oauth2.Token, error) {
+	type result struct {
+		token *oauth2.Token
+		err   error
+	}
+
+	c := make(chan result, 1)
+	go func() {
+		token, err := ts.Token()
+		c <- result{token, err}
+	}()
+
+	select {
+	case r := <-c:
+		return r.token, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
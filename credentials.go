@@ -0,0 +1,295 @@
+package gcp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// Values of the `type` field of a credentials JSON
+const (
+	serviceAccountKey  = "service_account"
+	externalAccountKey = "external_account"
+	authorizedUserKey  = "authorized_user"
+)
+
+// Executables sourcing subject tokens only run when this environment variable is set to 1
+const allowExecutablesEnv = "GOOGLE_EXTERNAL_ACCOUNT_ALLOW_EXECUTABLES"
+
+type (
+	// A typed credentials JSON. Each type checks the fields it needs so that misconfigurations fail
+	// when the `Gcp` struct is constructed rather than on the first token request.
+	credentialsKey interface {
+		validate() error
+		// Universe domain of the APIs the credentials belong to, empty for the default one
+		universeDomain() string
+	}
+
+	// User credentials written by `gcloud auth application-default login`. Access tokens are refreshed
+	// through the OAuth2 token endpoint with the refresh token.
+	AuthorizedUserKey struct {
+		Type           string `json:"type"`
+		ClientID       string `json:"client_id"`
+		ClientSecret   string `json:"client_secret"`
+		RefreshToken   string `json:"refresh_token"`
+		QuotaProjectID string `json:"quota_project_id,omitempty"`
+		UniverseDomain string `json:"universe_domain,omitempty"`
+	}
+
+	// Workload Identity Federation configuration. The subject token is read from a file, an URL or
+	// the output of an executable and exchanged through STS, then optionally used to impersonate a
+	// service account.
+	ExternalAccountKey struct {
+		Type                           string                   `json:"type"`
+		Audience                       string                   `json:"audience"`
+		SubjectTokenType               string                   `json:"subject_token_type"`
+		TokenURL                       string                   `json:"token_url"`
+		TokenInfoURL                   string                   `json:"token_info_url,omitempty"`
+		ServiceAccountImpersonationURL string                   `json:"service_account_impersonation_url,omitempty"`
+		CredentialSource               ExternalCredentialSource `json:"credential_source"`
+		QuotaProjectID                 string                   `json:"quota_project_id,omitempty"`
+		WorkforcePoolUserProject       string                   `json:"workforce_pool_user_project,omitempty"`
+		UniverseDomain                 string                   `json:"universe_domain,omitempty"`
+	}
+
+	// Exactly one of `File`, `URL` or `Executable` has to be set, unless `EnvironmentID` selects AWS.
+	ExternalCredentialSource struct {
+		EnvironmentID string                      `json:"environment_id,omitempty"`
+		File          string                      `json:"file,omitempty"`
+		URL           string                      `json:"url,omitempty"`
+		Headers       map[string]string           `json:"headers,omitempty"`
+		Executable    *ExecutableCredentialSource `json:"executable,omitempty"`
+		Format        *CredentialSourceFormat     `json:"format,omitempty"`
+	}
+
+	ExecutableCredentialSource struct {
+		Command       string `json:"command"`
+		TimeoutMillis int    `json:"timeout_millis,omitempty"`
+		OutputFile    string `json:"output_file,omitempty"`
+	}
+
+	// Format of the subject token, either `text` or `json` with the field holding the token.
+	CredentialSourceFormat struct {
+		Type                  string `json:"type"`
+		SubjectTokenFieldName string `json:"subject_token_field_name,omitempty"`
+	}
+)
+
+// The function parses a credentials JSON into the typed key matching its `type` field and validates it.
+func parseCredentialsKey(b []byte) (credentialsKey, error) {
+	var f struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal credentials <%w>", err)
+	}
+
+	var k credentialsKey
+	switch f.Type {
+	case serviceAccountKey:
+		k = &ServiceAccountKey{}
+	case externalAccountKey:
+		k = &ExternalAccountKey{}
+	case authorizedUserKey:
+		k = &AuthorizedUserKey{}
+	default:
+		return nil, fmt.Errorf("unsupported credentials type '%s'", f.Type)
+	}
+
+	if err := json.Unmarshal(b, k); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal %s credentials <%w>", f.Type, err)
+	}
+	if err := k.validate(); err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func (k *ServiceAccountKey) validate() error {
+	if k.ClientEmail == "" || k.PrivateKey == "" {
+		return fmt.Errorf("%s credentials require client_email and private_key", k.Type)
+	}
+
+	return nil
+}
+
+func (k *AuthorizedUserKey) validate() error {
+	if k.ClientID == "" || k.ClientSecret == "" || k.RefreshToken == "" {
+		return fmt.Errorf("%s credentials require client_id, client_secret and refresh_token", k.Type)
+	}
+
+	return nil
+}
+
+func (k *ServiceAccountKey) universeDomain() string {
+	return k.UniverseDomain
+}
+
+func (k *AuthorizedUserKey) universeDomain() string {
+	return k.UniverseDomain
+}
+
+func (k *ExternalAccountKey) universeDomain() string {
+	return k.UniverseDomain
+}
+
+func (k *ExternalAccountKey) validate() error {
+	if k.Audience == "" || k.SubjectTokenType == "" || k.TokenURL == "" {
+		return fmt.Errorf("%s credentials require audience, subject_token_type and token_url", k.Type)
+	}
+
+	// AWS sources carry their own set of URLs which the oauth2 package checks
+	if k.CredentialSource.EnvironmentID != "" {
+		return nil
+	}
+
+	sources := 0
+	for _, set := range []bool{k.CredentialSource.File != "", k.CredentialSource.URL != "", k.CredentialSource.Executable != nil} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("%s credentials require exactly one of file, url or executable credential_source", k.Type)
+	}
+
+	if k.CredentialSource.Executable != nil && os.Getenv(allowExecutablesEnv) != "1" {
+		return fmt.Errorf("executable-sourced credentials require %s=1 to run %s", allowExecutablesEnv, k.CredentialSource.Executable.Command)
+	}
+
+	return nil
+}
+
+// The function resolves the credentials shared by every client of the `Gcp` struct. An explicit key
+// takes precedence, otherwise the Application Default Credentials chain is searched in order:
+// GOOGLE_APPLICATION_CREDENTIALS, the gcloud well-known file and the GCE/GKE metadata server.
+func findCredentials(ctx context.Context, keyByte []byte, scope []string) (*google.Credentials, error) {
+	if keyByte != nil {
+		c, err := google.CredentialsFromJSON(ctx, keyByte, scope...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read credentials for scope %s <%w>", scope, err)
+		}
+
+		return c, nil
+	}
+
+	c, err := google.FindDefaultCredentials(ctx, scope...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find application default credentials for scope %s <%w>", scope, err)
+	}
+
+	return c, nil
+}
+
+// This is a method of the `Gcp` struct that returns the token source of a given set of scopes from its
+// registry. Tokens are served from the process-wide cache so that every VU sharing the same
+// credentials and scopes reuses one token until it nears expiry. Tokens are fetched with the lifetime
+// context of the VU, since the token source outlives the call that created it.
+func (g *Gcp) tokenSource(scope []string) (oauth2.TokenSource, error) {
+	if g.credentials == nil {
+		return nil, fmt.Errorf("no credentials configured for scope %s", scope)
+	}
+
+	key := accessTokenKey(scope)
+
+	return g.tokenSources.get(key, func() oauth2.TokenSource {
+		return g.cachedTokenSource(key, "access_token", func() (*oauth2.Token, error) {
+			ts, err := g.newTokenSource(g.lifetime, scope)
+			if err != nil {
+				return nil, err
+			}
+
+			return ts.Token()
+		})
+	}), nil
+}
+
+// This is a method of the `Gcp` struct that returns a token source backed by the process-wide token
+// cache. The cache key combines the credential identity with the given key. Token fetches are measured
+// as calls of the `oauth2` service.
+func (g *Gcp) cachedTokenSource(key string, tokenType string, fetch func() (*oauth2.Token, error)) oauth2.TokenSource {
+	return cachedTokenSource{
+		cache: g.tokens,
+		key:   g.credentialsIdentity() + "|" + key,
+		fetch: func() (_ *oauth2.Token, err error) {
+			r := g.startRequest("oauth2", tokenType, "")
+			r.startSpan(g.lifetime)
+			defer r.end(&err)
+
+			return fetch()
+		},
+		report: func(hit bool) {
+			m := g.metrics.TokenCacheMisses
+			if hit {
+				m = g.metrics.TokenCacheHits
+			}
+			g.pushMetric(m, 1, map[string]string{"token_type": tokenType})
+		},
+	}
+}
+
+// This is a method of the `Gcp` struct that forces the token of a key to be refreshed, e.g. after it was
+// rejected with a 401.
+func (g *Gcp) invalidateToken(key string, token *oauth2.Token) {
+	g.tokens.invalidate(g.credentialsIdentity()+"|"+key, token.AccessToken)
+}
+
+func accessTokenKey(scope []string) string {
+	return "scope:" + scopeKey(scope)
+}
+
+func idTokenKey(audience string, includeEmail bool) string {
+	return fmt.Sprintf("audience:%s|%t", audience, includeEmail)
+}
+
+// This is a method of the `Gcp` struct that identifies its credentials without exposing them. Credentials
+// JSON are hashed, the metadata server is shared by the whole process.
+func (g *Gcp) credentialsIdentity() string {
+	id := "metadata"
+	if g.credentials != nil && g.credentials.JSON != nil {
+		id = fmt.Sprintf("%x", sha256.Sum256(g.credentials.JSON))
+	}
+
+	if g.impersonateServiceAccount != "" {
+		id += "|" + g.impersonateServiceAccount + "|" + strings.Join(g.delegates, ",")
+	}
+
+	return id
+}
+
+// This is a method of the `Gcp` struct that returns a new token source for a given set of scopes. When a
+// service account is impersonated, tokens are minted through IAM Credentials on behalf of it.
+func (g *Gcp) newTokenSource(ctx context.Context, scope []string) (oauth2.TokenSource, error) {
+	if g.impersonateServiceAccount != "" {
+		return g.impersonatedTokenSource(ctx, scope)
+	}
+
+	return g.credentialsTokenSource(ctx, scope)
+}
+
+// This is a method of the `Gcp` struct that returns a token source of the resolved credentials.
+// Credentials backed by a JSON file are re-read with the requested scopes, while credentials from
+// the metadata server ask the metadata server for a token with those scopes.
+func (g *Gcp) credentialsTokenSource(ctx context.Context, scope []string) (oauth2.TokenSource, error) {
+	if g.credentials == nil {
+		return nil, fmt.Errorf("no credentials configured for scope %s", scope)
+	}
+
+	if g.credentials.JSON == nil {
+		return google.ComputeTokenSource("", scope...), nil
+	}
+
+	c, err := findCredentials(ctx, g.credentials.JSON, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.TokenSource, nil
+}
@@ -0,0 +1,228 @@
+package gcp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/oauth2/google"
+)
+
+func TestParseCredentialsKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		allow bool
+		err   string
+	}{
+		{"service account", `{"type":"service_account","client_email":"sa@p.iam.gserviceaccount.com","private_key":"key"}`, false, ""},
+		{"service account without private key", `{"type":"service_account","client_email":"sa@p.iam.gserviceaccount.com"}`, false, "require client_email and private_key"},
+		{"authorized user", `{"type":"authorized_user","client_id":"id","client_secret":"secret","refresh_token":"token"}`, false, ""},
+		{"authorized user without refresh token", `{"type":"authorized_user","client_id":"id","client_secret":"secret"}`, false, "require client_id, client_secret and refresh_token"},
+		{"external account from file", `{"type":"external_account","audience":"aud","subject_token_type":"jwt","token_url":"https://sts","credential_source":{"file":"/token"}}`, false, ""},
+		{"external account from AWS", `{"type":"external_account","audience":"aud","subject_token_type":"aws","token_url":"https://sts","credential_source":{"environment_id":"aws1"}}`, false, ""},
+		{"external account without token URL", `{"type":"external_account","audience":"aud","subject_token_type":"jwt","credential_source":{"file":"/token"}}`, false, "require audience, subject_token_type and token_url"},
+		{"external account without source", `{"type":"external_account","audience":"aud","subject_token_type":"jwt","token_url":"https://sts"}`, false, "exactly one of file, url or executable"},
+		{"external account with two sources", `{"type":"external_account","audience":"aud","subject_token_type":"jwt","token_url":"https://sts","credential_source":{"file":"/token","url":"http://token"}}`, false, "exactly one of file, url or executable"},
+		{"external account from executable", `{"type":"external_account","audience":"aud","subject_token_type":"jwt","token_url":"https://sts","credential_source":{"executable":{"command":"token"}}}`, true, ""},
+		{"external account from disallowed executable", `{"type":"external_account","audience":"aud","subject_token_type":"jwt","token_url":"https://sts","credential_source":{"executable":{"command":"token"}}}`, false, allowExecutablesEnv + "=1"},
+		{"unsupported type", `{"type":"api_key"}`, false, "unsupported credentials type 'api_key'"},
+		{"invalid JSON", `{"type":`, false, "cannot unmarshal credentials"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.allow {
+				t.Setenv(allowExecutablesEnv, "1")
+			} else {
+				t.Setenv(allowExecutablesEnv, "")
+			}
+
+			_, err := parseCredentialsKey([]byte(tt.key))
+			if tt.err == "" && err != nil {
+				t.Fatalf("expected credentials to be valid, got %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestCredentialsTokenSourceFromMetadataServer(t *testing.T) {
+	// GCE is detected once per process, the test runs in a process of its own so that the other tests
+	// do not take the stand-in for their environment
+	if os.Getenv("XK6_GCP_METADATA_TEST") == "" {
+		cmd := exec.Command(os.Args[0], "-test.run=^TestCredentialsTokenSourceFromMetadataServer$")
+		cmd.Env = append(os.Environ(), "XK6_GCP_METADATA_TEST=1")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%v\n%s", err, out)
+		}
+		return
+	}
+
+	var scopes string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" || r.URL.Path != "/computeMetadata/v1/instance/service-accounts/default/token" {
+			http.NotFound(w, r)
+			return
+		}
+		scopes = r.URL.Query().Get("scopes")
+
+		w.Header().Set("Metadata-Flavor", "Google")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"metadata-token","expires_in":3600,"token_type":"Bearer"}`))
+	}))
+	t.Cleanup(server.Close)
+	t.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(server.URL, "http://"))
+
+	g := &Gcp{credentials: &google.Credentials{}}
+	ts, err := g.credentialsTokenSource(context.Background(), []string{"scope-a", "scope-b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "metadata-token" {
+		t.Errorf("expected the token of the metadata server, got %s", token.AccessToken)
+	}
+	if scopes != "scope-a,scope-b" {
+		t.Errorf("expected the requested scopes, got %s", scopes)
+	}
+}
+
+func TestFindCredentialsExchangesFileSourcedToken(t *testing.T) {
+	dir := t.TempDir()
+	tokenFile := filepath.Join(dir, "token")
+	if err := os.WriteFile(tokenFile, []byte("subject-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var form map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Error(err)
+		}
+		form = map[string]string{
+			"audience":      r.PostForm.Get("audience"),
+			"subject_token": r.PostForm.Get("subject_token"),
+			"scope":         r.PostForm.Get("scope"),
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"federated-token","issued_token_type":"urn:ietf:params:oauth:token-type:access_token","token_type":"Bearer","expires_in":3600}`))
+	}))
+	t.Cleanup(server.Close)
+
+	key, err := json.Marshal(ExternalAccountKey{
+		Type:             externalAccountKey,
+		Audience:         "//iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/pool/providers/provider",
+		SubjectTokenType: "urn:ietf:params:oauth:token-type:jwt",
+		TokenURL:         server.URL,
+		CredentialSource: ExternalCredentialSource{File: tokenFile},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseCredentialsKey(key); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := findCredentials(context.Background(), key, []string{"scope-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := c.TokenSource.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "federated-token" {
+		t.Errorf("expected the token of STS, got %s", token.AccessToken)
+	}
+	if form["subject_token"] != "subject-token" {
+		t.Errorf("expected the subject token of the file to be exchanged, got %s", form["subject_token"])
+	}
+	if !strings.HasSuffix(form["audience"], "/providers/provider") {
+		t.Errorf("expected the audience of the key, got %s", form["audience"])
+	}
+	if form["scope"] != "scope-a" {
+		t.Errorf("expected the requested scopes, got %s", form["scope"])
+	}
+}
+
+// The function creates an instance outside of a VU, sharing the caches of a new root module.
+func newTestGcp(t *testing.T, opts ...Option) *Gcp {
+	t.Helper()
+
+	root := New()
+	module := func(g *Gcp) error {
+		g.tokens = root.tokens
+		g.jwks = root.jwks
+		g.identities = root.identities
+		g.metrics = &gcpMetrics{}
+
+		return nil
+	}
+
+	g, err := newGcpConstructor(append([]Option{module}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return g
+}
+
+// The function returns the key of a service account with a new private key, whose access tokens are
+// minted by the given token endpoint.
+func testServiceAccountKey(t *testing.T, tokenUrl string) map[string]interface{} {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return map[string]interface{}{
+		"type":           serviceAccountKey,
+		"client_email":   "sa@p.iam.gserviceaccount.com",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b})),
+		"private_key_id": "key-1",
+		"project_id":     "p",
+		"token_uri":      tokenUrl,
+	}
+}
+
+// The function serves an OAuth2 token endpoint minting the access tokens token-1, token-2 and so on,
+// and counts them.
+func tokenServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	var tokens atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":3600}`, tokens.Add(1))
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &tokens
+}
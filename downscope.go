@@ -0,0 +1,216 @@
+package gcp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	defaultStsUrl = "https://sts.googleapis.com/v1/token"
+	// STS accepts at most this many rules in a Credential Access Boundary
+	maxAccessBoundaryRules = 10
+)
+
+type (
+	// Options of `DownscopedToken`
+	DownscopedTokenOptions struct {
+		// Rules of the Credential Access Boundary, at least one and at most ten
+		Rules []AccessBoundaryRule
+		// Scopes of the base access token, defaults to the scopes of the `Gcp` struct
+		Scope []string
+		// Token exchange endpoint, e.g. a local STS stand-in
+		StsUrl string
+		// Credential profile of the base access token
+		Profile string
+		// Maximum time to wait for the token, e.g. `10s`
+		Timeout string
+	}
+
+	// Rule of a Credential Access Boundary. The downscoped token only holds the permissions of the roles
+	// on the resource, further restricted by the condition.
+	AccessBoundaryRule struct {
+		// Full resource name, e.g. `//storage.googleapis.com/projects/_/buckets/my-bucket`
+		Resource string
+		// IAM roles, e.g. `roles/storage.objectViewer`
+		Roles     []string
+		Condition *AccessBoundaryCondition
+	}
+
+	// IAM condition of a rule, e.g. restricting objects to a prefix
+	AccessBoundaryCondition struct {
+		Expression  string
+		Title       string
+		Description string
+	}
+
+	// The token source exchanges access tokens of the base token source for downscoped tokens through
+	// the STS token exchange endpoint.
+	downscopedTokenSource struct {
+		ctx context.Context
+		// Transport of the token exchange, over the network stack of the VU
+		transport http.RoundTripper
+		base      oauth2.TokenSource
+		endpoint  string
+		boundary  string
+	}
+
+	accessBoundary struct {
+		AccessBoundary struct {
+			AccessBoundaryRules []accessBoundaryRule `json:"accessBoundaryRules"`
+		} `json:"accessBoundary"`
+	}
+
+	accessBoundaryRule struct {
+		AvailableResource     string                 `json:"availableResource"`
+		AvailablePermissions  []string               `json:"availablePermissions"`
+		AvailabilityCondition *availabilityCondition `json:"availabilityCondition,omitempty"`
+	}
+
+	availabilityCondition struct {
+		Expression  string `json:"expression"`
+		Title       string `json:"title,omitempty"`
+		Description string `json:"description,omitempty"`
+	}
+)
+
+func (d downscopedTokenSource) Token() (*oauth2.Token, error) {
+	base, err := d.base.Token()
+	if err != nil {
+		return nil, fmt.Errorf("unable to obtain base access token <%w>", err)
+	}
+
+	form := url.Values{
+		"grant_type":           {"urn:ietf:params:oauth:grant-type:token-exchange"},
+		"subject_token_type":   {"urn:ietf:params:oauth:token-type:access_token"},
+		"requested_token_type": {"urn:ietf:params:oauth:token-type:access_token"},
+		"subject_token":        {base.AccessToken},
+		"options":              {d.boundary},
+	}
+
+	req, err := http.NewRequestWithContext(d.ctx, http.MethodPost, d.endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := (&http.Client{Transport: d.transport}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to exchange token through %s <%w>", d.endpoint, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to exchange token through %s, status %d", d.endpoint, res.StatusCode)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal downscoped token <%w>", err)
+	}
+
+	// Downscoped tokens expire along with the base token unless STS tells otherwise
+	expiry := base.Expiry
+	if body.ExpiresIn > 0 {
+		expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+
+	return &oauth2.Token{
+		AccessToken: body.AccessToken,
+		TokenType:   "Bearer",
+		Expiry:      expiry,
+	}, nil
+}
+
+// This is a method of the `Gcp` struct that returns the token source of a Credential Access Boundary,
+// backed by the process-wide token cache so that every VU asking for the same boundary shares a token.
+func (g *Gcp) downscopedTokenSource(options DownscopedTokenOptions) (oauth2.TokenSource, error) {
+	boundary, err := accessBoundaryOptions(options.Rules)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := options.Scope
+	if len(scope) == 0 {
+		scope = g.scope
+	}
+
+	base, err := g.tokenSource(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := options.StsUrl
+	if endpoint == "" {
+		endpoint = defaultStsUrl
+	}
+
+	key := fmt.Sprintf("downscoped:%s|%s|%x", scopeKey(scope), endpoint, sha256.Sum256([]byte(boundary)))
+
+	return g.tokenSources.get(key, func() oauth2.TokenSource {
+		return g.cachedTokenSource(key, "downscoped_token", downscopedTokenSource{
+			ctx:       g.lifetime,
+			transport: g.networkTransport(),
+			base:      base,
+			endpoint:  endpoint,
+			boundary:  boundary,
+		}.Token)
+	}), nil
+}
+
+// The function encodes the rules of a Credential Access Boundary as the `options` of a token exchange.
+// Roles are turned into `inRole:` permissions.
+func accessBoundaryOptions(rules []AccessBoundaryRule) (string, error) {
+	if len(rules) == 0 || len(rules) > maxAccessBoundaryRules {
+		return "", fmt.Errorf("a Credential Access Boundary requires between 1 and %d rules, got %d", maxAccessBoundaryRules, len(rules))
+	}
+
+	var b accessBoundary
+	for i, r := range rules {
+		if r.Resource == "" {
+			return "", fmt.Errorf("rule %d of the Credential Access Boundary has no resource", i)
+		}
+		if len(r.Roles) == 0 {
+			return "", fmt.Errorf("rule %d of the Credential Access Boundary has no roles", i)
+		}
+
+		rule := accessBoundaryRule{AvailableResource: r.Resource}
+		for _, role := range r.Roles {
+			if !strings.HasPrefix(role, "inRole:") {
+				role = "inRole:" + role
+			}
+			rule.AvailablePermissions = append(rule.AvailablePermissions, role)
+		}
+
+		if r.Condition != nil {
+			if r.Condition.Expression == "" {
+				return "", fmt.Errorf("condition of rule %d of the Credential Access Boundary has no expression", i)
+			}
+			rule.AvailabilityCondition = &availabilityCondition{
+				Expression:  r.Condition.Expression,
+				Title:       r.Condition.Title,
+				Description: r.Condition.Description,
+			}
+		}
+
+		b.AccessBoundary.AccessBoundaryRules = append(b.AccessBoundary.AccessBoundaryRules, rule)
+	}
+
+	o, err := json.Marshal(b)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal Credential Access Boundary <%w>", err)
+	}
+
+	return string(o), nil
+}
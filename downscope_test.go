@@ -0,0 +1,144 @@
+package gcp
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestAccessBoundaryOptions(t *testing.T) {
+	bucket := "//storage.googleapis.com/projects/_/buckets/my-bucket"
+	tooMany := make([]AccessBoundaryRule, maxAccessBoundaryRules+1)
+	for i := range tooMany {
+		tooMany[i] = AccessBoundaryRule{Resource: bucket, Roles: []string{"roles/storage.objectViewer"}}
+	}
+
+	tests := []struct {
+		name     string
+		rules    []AccessBoundaryRule
+		expected string
+		err      string
+	}{
+		{
+			name:     "roles",
+			rules:    []AccessBoundaryRule{{Resource: bucket, Roles: []string{"roles/storage.objectViewer", "inRole:roles/storage.objectCreator"}}},
+			expected: `{"accessBoundary":{"accessBoundaryRules":[{"availableResource":"` + bucket + `","availablePermissions":["inRole:roles/storage.objectViewer","inRole:roles/storage.objectCreator"]}]}}`,
+		},
+		{
+			name:     "condition",
+			rules:    []AccessBoundaryRule{{Resource: bucket, Roles: []string{"roles/storage.objectViewer"}, Condition: &AccessBoundaryCondition{Expression: "true", Title: "all"}}},
+			expected: `{"accessBoundary":{"accessBoundaryRules":[{"availableResource":"` + bucket + `","availablePermissions":["inRole:roles/storage.objectViewer"],"availabilityCondition":{"expression":"true","title":"all"}}]}}`,
+		},
+		{name: "no rules", err: "between 1 and 10 rules, got 0"},
+		{name: "too many rules", rules: tooMany, err: "between 1 and 10 rules, got 11"},
+		{name: "no resource", rules: []AccessBoundaryRule{{Roles: []string{"roles/storage.objectViewer"}}}, err: "rule 0 of the Credential Access Boundary has no resource"},
+		{name: "no roles", rules: []AccessBoundaryRule{{Resource: bucket}}, err: "rule 0 of the Credential Access Boundary has no roles"},
+		{name: "condition without expression", rules: []AccessBoundaryRule{{Resource: bucket, Roles: []string{"roles/storage.objectViewer"}, Condition: &AccessBoundaryCondition{Title: "all"}}}, err: "has no expression"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := accessBoundaryOptions(tt.rules)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if options != tt.expected {
+				t.Errorf("expected options\n%s\ngot\n%s", tt.expected, options)
+			}
+		})
+	}
+}
+
+func TestDownscopedTokenSourceExchangesBaseToken(t *testing.T) {
+	baseExpiry := time.Now().Add(time.Hour).Round(time.Second)
+
+	tests := []struct {
+		name     string
+		response string
+		status   int
+		expiry   func(time.Time) bool
+		err      string
+	}{
+		{
+			name:     "expiry of STS",
+			response: `{"access_token":"downscoped-token","token_type":"Bearer","expires_in":600}`,
+			status:   http.StatusOK,
+			expiry:   func(e time.Time) bool { return e.Before(baseExpiry) && e.After(time.Now()) },
+		},
+		{
+			name:     "expiry of the base token",
+			response: `{"access_token":"downscoped-token","token_type":"Bearer"}`,
+			status:   http.StatusOK,
+			expiry:   func(e time.Time) bool { return e.Equal(baseExpiry) },
+		},
+		{
+			name:     "rejected",
+			response: `{"error":"invalid_grant"}`,
+			status:   http.StatusBadRequest,
+			err:      "status 400",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var form map[string]string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if err := r.ParseForm(); err != nil {
+					t.Error(err)
+				}
+				form = map[string]string{}
+				for k := range r.PostForm {
+					form[k] = r.PostForm.Get(k)
+				}
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.response))
+			}))
+			t.Cleanup(server.Close)
+
+			ts := downscopedTokenSource{
+				ctx:       context.Background(),
+				transport: http.DefaultTransport,
+				base:      oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "base-token", Expiry: baseExpiry}),
+				endpoint:  server.URL,
+				boundary:  `{"accessBoundary":{}}`,
+			}
+
+			token, err := ts.Token()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if token.AccessToken != "downscoped-token" {
+				t.Errorf("expected the token of STS, got %s", token.AccessToken)
+			}
+			if !tt.expiry(token.Expiry) {
+				t.Errorf("unexpected expiry %s", token.Expiry)
+			}
+			if form["subject_token"] != "base-token" || form["options"] != ts.boundary {
+				t.Errorf("expected the base token to be exchanged with the boundary, got %v", form)
+			}
+			if form["grant_type"] != "urn:ietf:params:oauth:grant-type:token-exchange" {
+				t.Errorf("unexpected grant type %s", form["grant_type"])
+			}
+		})
+	}
+}
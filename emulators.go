@@ -0,0 +1,131 @@
+package gcp
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+const (
+	emulatorPubsub    = "pubsub"
+	emulatorFirestore = "firestore"
+	emulatorDatastore = "datastore"
+	emulatorBigtable  = "bigtable"
+	emulatorSpanner   = "spanner"
+	emulatorStorage   = "storage"
+	// Firebase Authentication emulator, also serving Identity Platform
+	emulatorAuth = "auth"
+)
+
+// Environment variables the gcloud and Firebase emulators are announced with, per service
+var emulatorEnvs = map[string]string{
+	emulatorPubsub:    "PUBSUB_EMULATOR_HOST",
+	emulatorFirestore: "FIRESTORE_EMULATOR_HOST",
+	emulatorDatastore: "DATASTORE_EMULATOR_HOST",
+	emulatorBigtable:  "BIGTABLE_EMULATOR_HOST",
+	emulatorSpanner:   "SPANNER_EMULATOR_HOST",
+	emulatorStorage:   "STORAGE_EMULATOR_HOST",
+	emulatorAuth:      "FIREBASE_AUTH_EMULATOR_HOST",
+}
+
+// This is a method of the `Gcp` struct that returns the emulator host of a service, e.g. to send
+// `gcp.http` requests to the Cloud Storage emulator. It is empty when the real service is used.
+func (g *Gcp) Emulator(service string) string {
+	return g.emulators[service]
+}
+
+// Environment variable of the Pub/Sub emulator once taken out of the environment, guarded by
+// pubsubEmulatorEnvMu
+var (
+	pubsubEmulatorEnv   string
+	pubsubEmulatorEnvMu sync.Mutex
+)
+
+// This is a method of the `Gcp` struct that returns the client options of a gRPC service served by an
+// emulator: a connection dialed to it without TLS nor authentication, which the client closes along
+// with itself. It returns no options for the real service.
+func (g *Gcp) emulatorOptions(service string) ([]option.ClientOption, *grpc.ClientConn, error) {
+	host := g.emulators[service]
+	if host == "" {
+		return nil, nil, nil
+	}
+
+	conn, err := grpc.Dial(host, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not dial %s emulator %s <%w>", service, host, err)
+	}
+
+	return []option.ClientOption{
+		option.WithGRPCConn(conn),
+		// Emulators has no capability to authenticate
+		option.WithoutAuthentication(),
+	}, conn, nil
+}
+
+// The function returns the emulator host announced by the environment variable of a service. The
+// Pub/Sub client dials PUBSUB_EMULATOR_HOST on its own whatever its options are, so the variable is
+// taken out of the environment once read and kept by the module instead, leaving the emulator of each
+// instance to its configuration.
+func emulatorEnv(service string) string {
+	env := emulatorEnvs[service]
+	if service != emulatorPubsub {
+		return os.Getenv(env)
+	}
+
+	pubsubEmulatorEnvMu.Lock()
+	defer pubsubEmulatorEnvMu.Unlock()
+
+	if host, ok := os.LookupEnv(env); ok {
+		pubsubEmulatorEnv = host
+		_ = os.Unsetenv(env)
+	}
+
+	return pubsubEmulatorEnv
+}
+
+// The function resolves the emulator of each service. An entry of `emulators` takes precedence over the
+// `emulator_host` shared by every service, which takes precedence over the environment variable of the
+// service.
+func withGcpConstructorEmulators(host string, emulators map[string]string) func(*Gcp) error {
+	return func(g *Gcp) error {
+		for service := range emulators {
+			if _, ok := emulatorEnvs[service]; !ok {
+				return fmt.Errorf("unknown emulator %s, expected one of %s", service, strings.Join(emulatorServices(), ", "))
+			}
+		}
+
+		g.emulatorHost = host
+		g.emulatorsConfigured = host != "" || len(emulators) != 0
+		g.emulators = make(map[string]string, len(emulatorEnvs))
+		for service := range emulatorEnvs {
+			switch {
+			case emulators[service] != "":
+				g.emulators[service] = emulators[service]
+			case host != "":
+				g.emulators[service] = host
+			default:
+				if env := emulatorEnv(service); env != "" {
+					g.emulators[service] = env
+				}
+			}
+		}
+
+		return nil
+	}
+}
+
+func emulatorServices() []string {
+	services := make([]string, 0, len(emulatorEnvs))
+	for service := range emulatorEnvs {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+
+	return services
+}
@@ -0,0 +1,39 @@
+package gcp
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGcpConstructorCredentialsWithEmulators(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       string
+		host      string
+		emulators map[string]string
+		ok        bool
+	}{
+		{name: "no emulators"},
+		{name: "emulator of the environment", env: "localhost:8085"},
+		{name: "emulator_host", host: "localhost:9000", ok: true},
+		{name: "emulators", emulators: map[string]string{emulatorPubsub: "localhost:8085"}, ok: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Application Default Credentials point at a missing file
+			t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", filepath.Join(t.TempDir(), "missing.json"))
+			t.Setenv("GOOGLE_SERVICE_ACCOUNT_KEY", "")
+			t.Setenv("PUBSUB_EMULATOR_HOST", tt.env)
+			t.Cleanup(func() { pubsubEmulatorEnv = "" })
+
+			_, err := newGcpConstructor(withGcpConstructorEmulators(tt.host, tt.emulators), withGcpConstructorKey(nil, "GOOGLE_SERVICE_ACCOUNT_KEY"))
+			if tt.ok && err != nil {
+				t.Fatalf("expected credentials to be optional, got %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected missing credentials to fail")
+			}
+		})
+	}
+}
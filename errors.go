@@ -0,0 +1,216 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/genproto/googleapis/rpc/code"
+)
+
+// Canonical codes of the errors that are worth retrying
+var retryableCodes = map[string]bool{
+	"ABORTED":            true,
+	"DEADLINE_EXCEEDED":  true,
+	"INTERNAL":           true,
+	"RESOURCE_EXHAUSTED": true,
+	"UNAVAILABLE":        true,
+}
+
+type (
+	// Error returned by the Sheets, PubSub and Monitoring methods. It is thrown as a JS exception whose
+	// `value` holds these fields, e.g. `e.value.retryable`.
+	GcpError struct {
+		// API the call was made to, e.g. `sheets`
+		Service string
+		// Method of the module that failed, e.g. `spreadsheetGet`
+		Operation string
+		// Canonical error code, e.g. `NOT_FOUND` or `RESOURCE_EXHAUSTED`
+		Code string
+		// HTTP status of REST APIs, zero for gRPC APIs and local errors
+		HttpStatus int
+		Message    string
+		Retryable  bool
+		// Google API error details
+		ErrorInfo    *GcpErrorInfo
+		QuotaFailure []GcpQuotaViolation
+
+		err error
+	}
+
+	// The google.rpc.ErrorInfo detail of an API error
+	GcpErrorInfo struct {
+		Reason   string
+		Domain   string
+		Metadata map[string]string
+	}
+
+	// A violation of the google.rpc.QuotaFailure detail of an API error
+	GcpQuotaViolation struct {
+		Subject     string
+		Description string
+	}
+
+	// Error raised by the module itself with a canonical code, e.g. a missing column, and the HTTP status
+	// of the response it was raised for, if any
+	codedError struct {
+		code       string
+		httpStatus int
+		err        error
+	}
+)
+
+func (e *GcpError) Error() string {
+	return e.Message
+}
+
+func (e *GcpError) Unwrap() error {
+	return e.err
+}
+
+func (e codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e codedError) Unwrap() error {
+	return e.err
+}
+
+// The function tags an error raised by the module with a canonical code.
+func withCode(code string, err error) error {
+	return codedError{code: code, err: err}
+}
+
+// The function returns the error of a REST response that came back without error but with another status
+// than 200 OK, coded after the status.
+func httpStatusError(status int) error {
+	return codedError{
+		code:       httpStatusCode(status),
+		httpStatus: status,
+		err:        fmt.Errorf("unexpected HTTP status %d %s", status, http.StatusText(status)),
+	}
+}
+
+// The function turns an error into a `GcpError` of a service operation. The code and details are read
+// from Google API errors, gRPC status errors and context errors. Errors that already are a `GcpError`
+// are returned as is.
+func newGcpError(service string, operation string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var ge *GcpError
+	if errors.As(err, &ge) {
+		return err
+	}
+
+	e := &GcpError{
+		Service:   service,
+		Operation: operation,
+		Code:      code.Code_name[int32(code.Code_UNKNOWN)],
+		Message:   err.Error(),
+		err:       err,
+	}
+
+	// REST clients wrap an APIError holding the HTTP details, gRPC clients return a status error
+	var ae *apierror.APIError
+	if !errors.As(err, &ae) {
+		ae, _ = apierror.FromError(err)
+	}
+
+	var ce codedError
+	switch {
+	case errors.As(err, &ce):
+		e.Code = ce.code
+		e.HttpStatus = ce.httpStatus
+	case errors.Is(err, context.DeadlineExceeded):
+		e.Code = code.Code_name[int32(code.Code_DEADLINE_EXCEEDED)]
+	case errors.Is(err, context.Canceled):
+		e.Code = code.Code_name[int32(code.Code_CANCELLED)]
+	case ae != nil && ae.HTTPCode() > 0:
+		e.HttpStatus = ae.HTTPCode()
+		e.Code = httpStatusCode(ae.HTTPCode())
+	case ae != nil:
+		e.Code = code.Code_name[int32(ae.GRPCStatus().Code())]
+	}
+
+	if ae != nil {
+		d := ae.Details()
+		if d.ErrorInfo != nil {
+			e.ErrorInfo = &GcpErrorInfo{
+				Reason:   d.ErrorInfo.GetReason(),
+				Domain:   d.ErrorInfo.GetDomain(),
+				Metadata: d.ErrorInfo.GetMetadata(),
+			}
+		}
+		if d.QuotaFailure != nil {
+			for _, v := range d.QuotaFailure.GetViolations() {
+				e.QuotaFailure = append(e.QuotaFailure, GcpQuotaViolation{
+					Subject:     v.GetSubject(),
+					Description: v.GetDescription(),
+				})
+			}
+		}
+	}
+
+	e.Retryable = retryableCodes[e.Code]
+
+	return e
+}
+
+// The function turns the error returned by a method into a `GcpError`, to be deferred with a named
+// error result.
+func wrapGcpError(err *error, service string, operation string) {
+	*err = newGcpError(service, operation, *err)
+}
+
+// The function returns the canonical code of the error a call ended with, `OK` without error.
+func errorCode(err error) string {
+	if err == nil {
+		return code.Code_name[int32(code.Code_OK)]
+	}
+
+	var ge *GcpError
+	if errors.As(newGcpError("", "", err), &ge) {
+		return ge.Code
+	}
+
+	return code.Code_name[int32(code.Code_UNKNOWN)]
+}
+
+// The function maps an HTTP status to its canonical code, see
+// https://cloud.google.com/apis/design/errors#handling_errors.
+func httpStatusCode(status int) string {
+	c := code.Code_UNKNOWN
+
+	switch status {
+	case http.StatusBadRequest:
+		c = code.Code_INVALID_ARGUMENT
+	case http.StatusUnauthorized:
+		c = code.Code_UNAUTHENTICATED
+	case http.StatusForbidden:
+		c = code.Code_PERMISSION_DENIED
+	case http.StatusNotFound:
+		c = code.Code_NOT_FOUND
+	case http.StatusConflict:
+		c = code.Code_ABORTED
+	case http.StatusPreconditionFailed:
+		c = code.Code_FAILED_PRECONDITION
+	case http.StatusTooManyRequests:
+		c = code.Code_RESOURCE_EXHAUSTED
+	case 499:
+		c = code.Code_CANCELLED
+	case http.StatusInternalServerError:
+		c = code.Code_INTERNAL
+	case http.StatusNotImplemented:
+		c = code.Code_UNIMPLEMENTED
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		c = code.Code_UNAVAILABLE
+	case http.StatusGatewayTimeout:
+		c = code.Code_DEADLINE_EXCEEDED
+	}
+
+	return code.Code_name[int32(c)]
+}
@@ -0,0 +1,93 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHttpStatusCode(t *testing.T) {
+	tests := []struct {
+		status   int
+		expected string
+	}{
+		{http.StatusBadRequest, "INVALID_ARGUMENT"},
+		{http.StatusUnauthorized, "UNAUTHENTICATED"},
+		{http.StatusForbidden, "PERMISSION_DENIED"},
+		{http.StatusNotFound, "NOT_FOUND"},
+		{http.StatusConflict, "ABORTED"},
+		{http.StatusPreconditionFailed, "FAILED_PRECONDITION"},
+		{http.StatusTooManyRequests, "RESOURCE_EXHAUSTED"},
+		{499, "CANCELLED"},
+		{http.StatusInternalServerError, "INTERNAL"},
+		{http.StatusNotImplemented, "UNIMPLEMENTED"},
+		{http.StatusBadGateway, "UNAVAILABLE"},
+		{http.StatusServiceUnavailable, "UNAVAILABLE"},
+		{http.StatusGatewayTimeout, "DEADLINE_EXCEEDED"},
+		{http.StatusTeapot, "UNKNOWN"},
+	}
+
+	for _, tt := range tests {
+		if c := httpStatusCode(tt.status); c != tt.expected {
+			t.Errorf("httpStatusCode(%d) = %s, expected %s", tt.status, c, tt.expected)
+		}
+	}
+}
+
+func TestNewGcpError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		code       string
+		httpStatus int
+		retryable  bool
+	}{
+		{"unknown", errors.New("boom"), "UNKNOWN", 0, false},
+		{"coded", withCode("INVALID_ARGUMENT", errors.New("bad column")), "INVALID_ARGUMENT", 0, false},
+		{"HTTP status", httpStatusError(http.StatusServiceUnavailable), "UNAVAILABLE", http.StatusServiceUnavailable, true},
+		{"deadline", fmt.Errorf("call failed <%w>", context.DeadlineExceeded), "DEADLINE_EXCEEDED", 0, true},
+		{"cancelled", context.Canceled, "CANCELLED", 0, false},
+		{"REST API", fmt.Errorf("call failed <%w>", &googleapi.Error{Code: http.StatusNotFound, Message: "not found"}), "NOT_FOUND", http.StatusNotFound, false},
+		{"REST API throttled", &googleapi.Error{Code: http.StatusTooManyRequests}, "RESOURCE_EXHAUSTED", http.StatusTooManyRequests, true},
+		{"gRPC API", status.Error(codes.Aborted, "conflict"), "ABORTED", 0, true},
+		{"gRPC API denied", status.Error(codes.PermissionDenied, "denied"), "PERMISSION_DENIED", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newGcpError("sheets", "spreadsheetGet", tt.err)
+
+			var ge *GcpError
+			if !errors.As(err, &ge) {
+				t.Fatalf("expected a GcpError, got %T", err)
+			}
+			if ge.Service != "sheets" || ge.Operation != "spreadsheetGet" {
+				t.Errorf("unexpected service %s and operation %s", ge.Service, ge.Operation)
+			}
+			if ge.Code != tt.code || ge.HttpStatus != tt.httpStatus || ge.Retryable != tt.retryable {
+				t.Errorf("expected code %s, status %d and retryable %t, got %s, %d and %t", tt.code, tt.httpStatus, tt.retryable, ge.Code, ge.HttpStatus, ge.Retryable)
+			}
+			if !errors.Is(err, tt.err) {
+				t.Error("expected the GcpError to wrap the original error")
+			}
+			if errorCode(tt.err) != tt.code {
+				t.Errorf("expected errorCode to be %s, got %s", tt.code, errorCode(tt.err))
+			}
+		})
+	}
+
+	if newGcpError("sheets", "spreadsheetGet", nil) != nil {
+		t.Error("expected no error without error")
+	}
+
+	ge := newGcpError("sheets", "spreadsheetGet", errors.New("boom"))
+	if newGcpError("pubsub", "publish", ge) != ge {
+		t.Error("expected a GcpError to be returned as is")
+	}
+}
@@ -0,0 +1,12 @@
+import { Gcp } from "k6/x/gcp";
+
+const gcp = new Gcp({
+  emulator_host: "localhost:9099",
+  project_id: "project-id",
+});
+export default function () {
+  const customToken = gcp.firebase.createCustomToken(`user-${__VU}`, { tier: "gold" });
+
+  const session = gcp.firebase.signInWithCustomToken(customToken);
+  console.log(session.id_token, session.refresh_token, session.expires_in);
+}
@@ -0,0 +1,25 @@
+import { Gcp } from "k6/x/gcp";
+
+const gcp = new Gcp({
+  emulator_host: "localhost:9099",
+  project_id: "project-id",
+});
+
+export const options = {
+  vus: 10,
+  iterations: 100,
+};
+
+export function setup() {
+  const uids = gcp.identity.provisionUsers(10, { email_domain: "example.com", claims: { tier: "gold" } });
+  console.log(`provisioned ${uids.length} users`);
+}
+
+export default function () {
+  const user = gcp.identity.userFor(__VU);
+  console.log(user.uid, user.email, user.id_token);
+}
+
+export function teardown() {
+  console.log(`deleted ${gcp.identity.deleteUsers()} users`);
+}
@@ -11,6 +11,6 @@ export default function() {
   const accessToken = gcp.getOAuth2AccessToken()
   console.log(accessToken)
 
-  const idToken = gcp.getOAuth2IdToken()
+  const idToken = gcp.getOAuth2IdToken('https://my-service-xxx.a.run.app', { include_email: true })
   console.log(idToken)
 }
@@ -0,0 +1,335 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/sobek"
+	"go.k6.io/k6/js/common"
+	"go.k6.io/k6/js/modules/k6/html"
+	"go.k6.io/k6/lib/netext/httpext"
+	"golang.org/x/oauth2"
+)
+
+// Timeout of `gcp.http` requests when none is given, same as k6/http
+const defaultHttpTimeout = 60 * time.Second
+
+type (
+	// Selects the token put in the `Authorization` header. An audience selects an ID token, otherwise an
+	// access token with the given scopes is used.
+	AuthOptions struct {
+		Audience     string
+		Scope        []string
+		IncludeEmail bool
+		// Credential profile the token is minted for
+		Profile string
+		// Maximum time to wait for the token, e.g. `10s`
+		Timeout string
+	}
+
+	// Params of `gcp.http` requests, a subset of the k6/http params plus the token selection.
+	HttpParams struct {
+		Headers      map[string]string
+		Tags         map[string]string
+		Timeout      string
+		Audience     string
+		Scope        []string
+		IncludeEmail bool
+		Profile      string
+	}
+
+	// Authenticated counterpart of k6/http, exposed as `gcp.http`. Requests go through the k6 HTTP
+	// stack, so the `http_req_*` metrics are emitted as usual.
+	GcpHttp struct {
+		g *Gcp
+	}
+
+	// Response of `gcp.http` requests, holding the fields of k6/http responses, e.g. `res.status` or
+	// `res.timings`, along with their `json()` and `html()` helpers.
+	GcpHttpResponse struct {
+		*httpext.Response `js:"-"`
+
+		rt         *sobek.Runtime
+		cachedJSON interface{}
+	}
+)
+
+// This is a method of the `Gcp` struct that returns the `Authorization` header for the selected token, to
+// be merged into the headers of a k6/http request.
+func (g *Gcp) AuthHeaders(options AuthOptions) (map[string]string, error) {
+	ctx, cancel, err := g.callContext(options.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	p, err := g.resolve(options.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	_, token, err := p.authToken(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]string{"Authorization": "Bearer " + token.AccessToken}, nil
+}
+
+// This is a method of the `Gcp` struct that returns the token selected by the options along with its key
+// in the token cache.
+func (g *Gcp) authToken(ctx context.Context, options AuthOptions) (string, *oauth2.Token, error) {
+	var key string
+	var ts oauth2.TokenSource
+	var err error
+
+	if options.Audience != "" {
+		key = idTokenKey(options.Audience, options.IncludeEmail)
+		ts, err = g.idTokenSource(options.Audience, options.IncludeEmail)
+	} else {
+		scope := options.Scope
+		if len(scope) == 0 {
+			scope = g.scope
+		}
+		key = accessTokenKey(scope)
+		ts, err = g.tokenSource(scope)
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	token, err := tokenWithContext(ctx, ts)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to obtain token for authorization header <%w>", err)
+	}
+
+	return key, token, nil
+}
+
+func (h *GcpHttp) Get(url string, params HttpParams) (*GcpHttpResponse, error) {
+	return h.Request(http.MethodGet, url, nil, params)
+}
+
+func (h *GcpHttp) Post(url string, body interface{}, params HttpParams) (*GcpHttpResponse, error) {
+	return h.Request(http.MethodPost, url, body, params)
+}
+
+func (h *GcpHttp) Put(url string, body interface{}, params HttpParams) (*GcpHttpResponse, error) {
+	return h.Request(http.MethodPut, url, body, params)
+}
+
+func (h *GcpHttp) Patch(url string, body interface{}, params HttpParams) (*GcpHttpResponse, error) {
+	return h.Request(http.MethodPatch, url, body, params)
+}
+
+func (h *GcpHttp) Del(url string, body interface{}, params HttpParams) (*GcpHttpResponse, error) {
+	return h.Request(http.MethodDelete, url, body, params)
+}
+
+// This function sends a request with a bearer token through the k6 HTTP stack. When the token is rejected
+// with a 401, it is refreshed and the request is retried once.
+func (h *GcpHttp) Request(method string, url string, body interface{}, params HttpParams) (*GcpHttpResponse, error) {
+	state := h.g.vu.State()
+	if state == nil {
+		return nil, fmt.Errorf("gcp.http requests cannot be made in the init context")
+	}
+
+	var b []byte
+	if body != nil {
+		var err error
+		if b, err = common.ToBytes(body); err != nil {
+			return nil, fmt.Errorf("unsupported body for %s %s <%w>", method, url, err)
+		}
+	}
+
+	timeout := defaultHttpTimeout
+	if params.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(params.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout %s <%w>", params.Timeout, err)
+		}
+	}
+
+	options := AuthOptions{
+		Audience:     params.Audience,
+		Scope:        params.Scope,
+		IncludeEmail: params.IncludeEmail,
+	}
+
+	p, err := h.g.resolve(params.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := h.g.vuContext()
+	key, token, err := p.authToken(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := h.do(ctx, method, url, b, timeout, token, params)
+	if err != nil || res.Status != http.StatusUnauthorized {
+		return h.response(res), err
+	}
+
+	p.invalidateToken(key, token)
+	if _, token, err = p.authToken(ctx, options); err != nil {
+		return nil, err
+	}
+
+	res, err = h.do(ctx, method, url, b, timeout, token, params)
+
+	return h.response(res), err
+}
+
+func (h *GcpHttp) response(res *httpext.Response) *GcpHttpResponse {
+	if res == nil {
+		return nil
+	}
+
+	return &GcpHttpResponse{Response: res, rt: h.g.vu.Runtime()}
+}
+
+func (h *GcpHttp) do(ctx context.Context, method string, rawURL string, body []byte, timeout time.Duration, token *oauth2.Token, params HttpParams) (*httpext.Response, error) {
+	state := h.g.vu.State()
+
+	u, err := httpext.NewURL(rawURL, rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	preq := &httpext.ParsedHTTPRequest{
+		URL: &u,
+		Req: &http.Request{
+			Method: method,
+			URL:    u.GetURL(),
+			Header: make(http.Header),
+		},
+		Timeout:   timeout,
+		Throw:     state.Options.Throw.Bool,
+		Redirects: state.Options.MaxRedirects,
+		Cookies:   make(map[string]*httpext.HTTPRequestCookie),
+		// Same expected statuses as k6/http, so that http_req_failed is reported consistently
+		ResponseCallback: func(status int) bool { return status >= 200 && status < 400 },
+		TagsAndMeta:      state.Tags.GetCurrentValues(),
+	}
+
+	if state.Options.DiscardResponseBodies.Bool {
+		preq.ResponseType = httpext.ResponseTypeNone
+	} else {
+		preq.ResponseType = httpext.ResponseTypeText
+	}
+
+	if body != nil {
+		preq.Body = bytes.NewBuffer(body)
+	}
+
+	// Same user agent and cookie jar as k6/http, the headers of the params take precedence. An empty
+	// user agent leaves the one of Go, like k6/http.
+	if state.Options.UserAgent.Valid && state.Options.UserAgent.String != "" {
+		preq.Req.Header.Set("User-Agent", state.Options.UserAgent.String)
+	}
+	if state.CookieJar != nil {
+		preq.ActiveJar = state.CookieJar
+	}
+
+	for k, v := range params.Headers {
+		preq.Req.Header.Set(k, v)
+	}
+	preq.Req.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+	for k, v := range params.Tags {
+		preq.TagsAndMeta.SetTag(k, v)
+	}
+
+	return httpext.MakeRequest(ctx, state, preq)
+}
+
+// This is a method of the `GcpHttpResponse` struct that parses the body as JSON, like the one of k6/http.
+// A selector returns the value at a dot-separated path instead, e.g. `items.0.id`, or undefined when the
+// path does not exist.
+func (res *GcpHttpResponse) JSON(selector ...string) sobek.Value {
+	if res.Body == nil {
+		common.Throw(res.rt, fmt.Errorf("the body is null so we can't transform it to JSON"+
+			" - this likely was because of a request error getting the response"))
+	}
+
+	if res.cachedJSON == nil {
+		body, err := common.ToBytes(res.Body)
+		if err != nil {
+			common.Throw(res.rt, err)
+		}
+
+		var v interface{}
+		if err := json.Unmarshal(body, &v); err != nil {
+			common.Throw(res.rt, fmt.Errorf("cannot parse json <%w>", err))
+		}
+		res.cachedJSON = v
+	}
+
+	if len(selector) == 0 {
+		return res.rt.ToValue(res.cachedJSON)
+	}
+
+	v, ok := jsonPath(res.cachedJSON, selector[0])
+	if !ok {
+		return sobek.Undefined()
+	}
+
+	return res.rt.ToValue(v)
+}
+
+// This is a method of the `GcpHttpResponse` struct that parses the body as HTML, like the one of k6/http,
+// optionally returning the elements matching a selector.
+func (res *GcpHttpResponse) HTML(selector ...string) html.Selection {
+	if res.Body == nil {
+		common.Throw(res.rt, fmt.Errorf("the body is null so we can't transform it to HTML"+
+			" - this likely was because of a request error getting the response"))
+	}
+
+	body, err := common.ToString(res.Body)
+	if err != nil {
+		common.Throw(res.rt, err)
+	}
+
+	sel, err := html.ParseHTML(res.rt, body)
+	if err != nil {
+		common.Throw(res.rt, err)
+	}
+	sel.URL = res.URL
+	if len(selector) > 0 {
+		sel = sel.Find(selector[0])
+	}
+
+	return sel
+}
+
+// The function returns the value at a dot-separated path of a JSON value, where array elements are
+// selected by index.
+func jsonPath(v interface{}, path string) (interface{}, bool) {
+	for _, key := range strings.Split(path, ".") {
+		switch value := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = value[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(value) {
+				return nil, false
+			}
+			v = value[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return v, true
+}
@@ -0,0 +1,189 @@
+package gcp
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"go.k6.io/k6/js/modulestest"
+	"go.k6.io/k6/lib"
+	"go.k6.io/k6/metrics"
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestGcpHttpAuthorizesRequests(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent null.String
+		expected  string
+	}{
+		{"user agent of the options", null.StringFrom("k6-test"), "k6-test"},
+		{"empty user agent", null.StringFrom(""), "Go-http-client/1.1"},
+		{"unset user agent", null.String{}, "Go-http-client/1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var header http.Header
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				header = r.Header
+			}))
+			t.Cleanup(server.Close)
+
+			tokens, _ := tokenServer(t)
+			mi, _ := newTestModuleInstance(t)
+			mi.vu.State().Options.UserAgent = tt.userAgent
+
+			g, err := mi.gcpFromConfig(GcpConfig{Key: testServiceAccountKey(t, tokens.URL)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := g.Http.Get(server.URL, HttpParams{Headers: map[string]string{"X-Test": "1"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Status != http.StatusOK {
+				t.Fatalf("expected status 200, got %d", res.Status)
+			}
+			if header.Get("Authorization") != "Bearer token-1" || header.Get("X-Test") != "1" {
+				t.Errorf("expected the access token along with the headers of the params, got %v", header)
+			}
+			if header.Get("User-Agent") != tt.expected {
+				t.Errorf("expected user agent %q, got %q", tt.expected, header.Get("User-Agent"))
+			}
+		})
+	}
+}
+
+func TestGcpHttpRetriesOnceOnUnauthorized(t *testing.T) {
+	tests := []struct {
+		name     string
+		accepted string
+		status   int
+		requests []string
+	}{
+		{"refreshed token", "Bearer token-2", http.StatusOK, []string{"Bearer token-1", "Bearer token-2"}},
+		{"rejected token", "", http.StatusUnauthorized, []string{"Bearer token-1", "Bearer token-2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var requests []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests = append(requests, r.Header.Get("Authorization"))
+				mu.Unlock()
+
+				if r.Header.Get("Authorization") != tt.accepted {
+					w.WriteHeader(http.StatusUnauthorized)
+				}
+			}))
+			t.Cleanup(server.Close)
+
+			tokens, _ := tokenServer(t)
+			mi, samples := newTestModuleInstance(t)
+			g, err := mi.gcpFromConfig(GcpConfig{Key: testServiceAccountKey(t, tokens.URL)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			res, err := g.Http.Post(server.URL, "{}", HttpParams{Tags: map[string]string{"endpoint": "orders"}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Status != tt.status {
+				t.Errorf("expected status %d, got %d", tt.status, res.Status)
+			}
+			if len(requests) != len(tt.requests) || requests[0] != tt.requests[0] || requests[1] != tt.requests[1] {
+				t.Errorf("expected requests with %v, got %v", tt.requests, requests)
+			}
+
+			durations := 0
+			for _, s := range collectSamples(samples) {
+				if s.Metric.Name != "http_req_duration" {
+					continue
+				}
+				durations++
+				if tag, _ := s.Tags.Get("endpoint"); tag != "orders" {
+					t.Errorf("expected the tags of the params on %s, got %v", s.Metric.Name, s.Tags.Map())
+				}
+				if method, _ := s.Tags.Get("method"); method != http.MethodPost {
+					t.Errorf("expected the method tag on %s, got %v", s.Metric.Name, s.Tags.Map())
+				}
+			}
+			if durations != 2 {
+				t.Errorf("expected an http_req_duration sample per request, got %d", durations)
+			}
+		})
+	}
+}
+
+// The function returns a module instance of a VU running its first iteration, along with the channel
+// the VU emits its samples to.
+func newTestModuleInstance(t *testing.T) (*ModuleInstance, chan metrics.SampleContainer) {
+	t.Helper()
+
+	_, mi, samples := newTestVu(t, New(), 1)
+	return mi, samples
+}
+
+// The function returns the runtime of a VU running its first iteration, the module instance of the root
+// module for the VU and the channel the VU emits its samples to.
+func newTestVu(t *testing.T, root *RootModule, vuId uint64) (*modulestest.Runtime, *ModuleInstance, chan metrics.SampleContainer) {
+	t.Helper()
+
+	rt := modulestest.NewRuntime(t)
+	registry := rt.VU.InitEnv().Registry
+	mi := root.NewModuleInstance(rt.VU).(*ModuleInstance)
+
+	logger := logrus.New()
+	logger.SetOutput(testLogWriter{t})
+
+	samples := make(chan metrics.SampleContainer, 1000)
+	rt.MoveToVUContext(&lib.State{
+		Options: lib.Options{
+			MaxRedirects: null.IntFrom(10),
+			SystemTags:   &metrics.DefaultSystemTagSet,
+		},
+		Logger:         logger,
+		Dialer:         &net.Dialer{},
+		TLSConfig:      &tls.Config{},
+		Transport:      http.DefaultTransport,
+		BufferPool:     lib.NewBufferPool(),
+		Samples:        samples,
+		Tags:           lib.NewVUStateTags(registry.RootTagSet().With("group", lib.RootGroupPath)),
+		BuiltinMetrics: rt.BuiltinMetrics,
+		VUID:           vuId,
+	})
+
+	return rt, mi, samples
+}
+
+// The function returns the samples emitted so far.
+func collectSamples(samples chan metrics.SampleContainer) []metrics.Sample {
+	var collected []metrics.Sample
+	for {
+		select {
+		case c := <-samples:
+			collected = append(collected, c.GetSamples()...)
+		default:
+			return collected
+		}
+	}
+}
+
+// Writes the logs of the VU to the test log
+type testLogWriter struct {
+	t *testing.T
+}
+
+func (w testLogWriter) Write(p []byte) (int, error) {
+	w.t.Log(string(p))
+	return len(p), nil
+}
@@ -0,0 +1,433 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/sync/errgroup"
+	identitytoolkit "google.golang.org/api/identitytoolkit/v1"
+	"google.golang.org/api/option"
+)
+
+const (
+	// Prefix of the IDs of provisioned users without a `uid_prefix` template
+	defaultIdentityUidPrefix = "k6-user-"
+	// The Identity Toolkit batchCreate and batchDelete endpoints accept at most this many users per call
+	identityBatchSize = 1000
+	// Concurrent sign-ins while provisioning users
+	identitySignInConcurrency = 16
+	// ID tokens of pooled users are refreshed this long before they expire, so that the token handed to
+	// a VU stays valid for its requests
+	identityTokenEarlyExpiry = 5 * time.Minute
+	// The Auth emulator accepts this bearer token for admin requests
+	firebaseEmulatorAdminToken = "owner"
+)
+
+type (
+	// Test users of Identity Platform, exposed as `gcp.identity`
+	GcpIdentity struct {
+		g *Gcp
+	}
+
+	// Template of the users created by `ProvisionUsers`
+	IdentityUserTemplate struct {
+		// Prefix of the user IDs, which are numbered from 1
+		UidPrefix string
+		// Users get the email `<uid>@<email_domain>` when set
+		EmailDomain string
+		// Password of every user, for apps signing users in with email and password
+		Password    string
+		DisplayName string
+		// Custom claims set on every user
+		Claims map[string]interface{}
+	}
+
+	// Provisioned user handed to a VU, with an ID token valid for at least a few minutes
+	IdentityUser struct {
+		Uid          string
+		Email        string
+		IdToken      string
+		RefreshToken string
+	}
+
+	// Process-wide pool of provisioned users shared by the `Gcp` instances of every VU, so that users
+	// provisioned in `setup` are available to all VUs.
+	identityPool struct {
+		mu    sync.Mutex
+		users []*pooledUser
+		// Users created by a provisioning that failed. They are never handed to VUs but deleted with the
+		// provisioned users.
+		orphans []*pooledUser
+		// Number of user IDs handed out, provisioned users are numbered after it
+		next int
+	}
+
+	// The user lock is held while its ID token is refreshed, so concurrent VUs wait for the refresh in
+	// flight.
+	pooledUser struct {
+		mu           sync.Mutex
+		uid          string
+		email        string
+		idToken      string
+		refreshToken string
+		expiry       time.Time
+	}
+)
+
+func newIdentityPool() *identityPool {
+	return &identityPool{}
+}
+
+// This function bulk-creates users from a template, signs each of them in with a custom token and adds
+// them to the process-wide pool. It returns the IDs of the created users. When provisioning fails, the
+// users created so far are kept aside for `DeleteUsers`.
+func (i *GcpIdentity) ProvisionUsers(count int, template IdentityUserTemplate) (_ []string, err error) {
+	ctx := i.g.vuContext()
+
+	if count <= 0 {
+		return nil, fmt.Errorf("count must be a positive number of users")
+	}
+
+	prefix := template.UidPrefix
+	if prefix == "" {
+		prefix = defaultIdentityUidPrefix
+	}
+
+	var attributes string
+	if len(template.Claims) != 0 {
+		b, err := json.Marshal(template.Claims)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal custom claims <%w>", err)
+		}
+		attributes = string(b)
+	}
+
+	c, err := i.g.identityAdminClient()
+	if err != nil {
+		return nil, err
+	}
+
+	start := i.g.identities.reserve(count)
+	users := make([]*pooledUser, count)
+	infos := make([]*identitytoolkit.GoogleCloudIdentitytoolkitV1UserInfo, count)
+	for n := range users {
+		u := &pooledUser{uid: prefix + strconv.Itoa(start+n+1)}
+		if template.EmailDomain != "" {
+			u.email = u.uid + "@" + template.EmailDomain
+		}
+		users[n] = u
+		infos[n] = &identitytoolkit.GoogleCloudIdentitytoolkitV1UserInfo{
+			LocalId:          u.uid,
+			Email:            u.email,
+			EmailVerified:    u.email != "",
+			RawPassword:      template.Password,
+			DisplayName:      template.DisplayName,
+			CustomAttributes: attributes,
+		}
+	}
+
+	var created []*pooledUser
+	defer func() {
+		if err != nil {
+			i.g.identities.orphan(created)
+		}
+	}()
+
+	for n := 0; n < count; n += identityBatchSize {
+		end := batchEnd(n, count)
+		req := &identitytoolkit.GoogleCloudIdentitytoolkitV1UploadAccountRequest{
+			AllowOverwrite: true,
+			Users:          infos[n:end],
+		}
+
+		res, err := identitytoolkit.NewProjectsAccountsService(c).BatchCreate(i.g.projectId, req).Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("unable to create users <%w>", err)
+		}
+
+		// Users of the batch are created unless they are reported as failed
+		failed := make(map[int64]bool, len(res.Error))
+		for _, e := range res.Error {
+			failed[e.Index] = true
+		}
+		for k, u := range users[n:end] {
+			if !failed[int64(k)] {
+				created = append(created, u)
+			}
+		}
+
+		if len(res.Error) != 0 {
+			e := res.Error[0]
+			return nil, fmt.Errorf("unable to create %d users, first error for %s: %s", len(res.Error), infos[n+int(e.Index)].LocalId, e.Message)
+		}
+	}
+
+	// The first failed sign-in, or the end of the VU context, cancels the others
+	eg, egCtx := errgroup.WithContext(ctx)
+	eg.SetLimit(identitySignInConcurrency)
+	for _, u := range users {
+		u := u
+		eg.Go(func() error {
+			return i.signIn(egCtx, u)
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	i.g.identities.add(users)
+
+	uids := make([]string, count)
+	for n, u := range users {
+		uids[n] = u.uid
+	}
+
+	return uids, nil
+}
+
+// This function returns the provisioned user of a VU, so that every VU gets a distinct user. The ID token
+// of the user is refreshed when it nears expiry.
+func (i *GcpIdentity) UserFor(vu int) (*IdentityUser, error) {
+	u, err := i.g.identities.user(vu)
+	if err != nil {
+		return nil, err
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if time.Now().Add(identityTokenEarlyExpiry).After(u.expiry) {
+		if err := i.refresh(i.g.vuContext(), u); err != nil {
+			return nil, err
+		}
+	}
+
+	return &IdentityUser{
+		Uid:          u.uid,
+		Email:        u.email,
+		IdToken:      u.idToken,
+		RefreshToken: u.refreshToken,
+	}, nil
+}
+
+// This function bulk-deletes every provisioned user, and the users of failed provisionings, and empties the
+// pool, typically in `teardown`. It returns the number of deleted users.
+func (i *GcpIdentity) DeleteUsers() (int, error) {
+	ctx := i.g.vuContext()
+
+	c, err := i.g.identityAdminClient()
+	if err != nil {
+		return 0, err
+	}
+
+	users := i.g.identities.drain()
+	uids := make([]string, len(users))
+	for n, u := range users {
+		uids[n] = u.uid
+	}
+
+	for n := 0; n < len(uids); n += identityBatchSize {
+		req := &identitytoolkit.GoogleCloudIdentitytoolkitV1BatchDeleteAccountsRequest{
+			Force:    true,
+			LocalIds: uids[n:batchEnd(n, len(uids))],
+		}
+
+		res, err := identitytoolkit.NewProjectsAccountsService(c).BatchDelete(i.g.projectId, req).Context(ctx).Do()
+		if err != nil {
+			return n, fmt.Errorf("unable to delete users <%w>", err)
+		}
+		if len(res.Errors) != 0 {
+			e := res.Errors[0]
+			return n, fmt.Errorf("unable to delete %d users, first error for %s: %s", len(res.Errors), e.LocalId, e.Message)
+		}
+	}
+
+	return len(uids), nil
+}
+
+// This function signs a pooled user in with a custom token.
+func (i *GcpIdentity) signIn(ctx context.Context, u *pooledUser) error {
+	token, err := i.g.Firebase.createCustomToken(ctx, u.uid, nil)
+	if err != nil {
+		return err
+	}
+
+	s, err := i.g.Firebase.signInWithCustomToken(ctx, token)
+	if err != nil {
+		return fmt.Errorf("unable to sign in user %s <%w>", u.uid, err)
+	}
+
+	return u.setTokens(s.IdToken, s.RefreshToken)
+}
+
+// This function exchanges the refresh token of a pooled user for a new ID token through the Secure Token
+// API, or the Auth emulator when an emulator host is configured.
+func (i *GcpIdentity) refresh(ctx context.Context, u *pooledUser) error {
+	endpoint := "https://securetoken.googleapis.com/v1/token"
+	apiKey := i.g.apiKey
+	if i.g.emulators[emulatorAuth] != "" {
+		endpoint = fmt.Sprintf("http://%s/securetoken.googleapis.com/v1/token", i.g.emulators[emulatorAuth])
+		if apiKey == "" {
+			apiKey = firebaseEmulatorApiKey
+		}
+	}
+	if apiKey == "" {
+		return fmt.Errorf("an API key is required to refresh Firebase users, please input 'api_key' parameter")
+	}
+
+	form := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {u.refreshToken},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint+"?key="+url.QueryEscape(apiKey), strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := (&http.Client{Transport: i.g.networkTransport()}).Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to refresh ID token of user %s <%w>", u.uid, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to refresh ID token of user %s, status %d", u.uid, res.StatusCode)
+	}
+
+	var body struct {
+		IdToken      string `json:"id_token"`
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return fmt.Errorf("unable to unmarshal refreshed tokens of user %s <%w>", u.uid, err)
+	}
+
+	return u.setTokens(body.IdToken, body.RefreshToken)
+}
+
+// This is a method of the `Gcp` struct that initializes the Identity Toolkit client used for admin
+// requests, authenticated with the configured credentials or as the owner of the Auth emulator.
+func (g *Gcp) identityAdminClient() (*identitytoolkit.Service, error) {
+	if g.projectId == "" {
+		return nil, fmt.Errorf("a project ID is required to manage users, please input 'project_id' parameter")
+	}
+
+	g.clientsMu.Lock()
+	defer g.clientsMu.Unlock()
+
+	if g.identityAdmin == nil {
+		if err := g.checkLifetime(); err != nil {
+			return nil, err
+		}
+
+		var options []option.ClientOption
+
+		if g.emulators[emulatorAuth] != "" {
+			options = append(options,
+				option.WithEndpoint(fmt.Sprintf("http://%s/identitytoolkit.googleapis.com/", g.emulators[emulatorAuth])),
+				option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: firebaseEmulatorAdminToken})),
+			)
+		} else {
+			ts, err := g.tokenSource(gcpConstructorDefaultScope)
+			if err != nil {
+				return nil, err
+			}
+			options = append(options, option.WithTokenSource(ts))
+		}
+
+		hc, err := g.restHttpClient(g.lifetime, options...)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := identitytoolkit.NewService(g.lifetime, append(options, option.WithHTTPClient(hc))...)
+		if err != nil {
+			return nil, fmt.Errorf("could not initialize Identity Toolkit admin client <%w>", err)
+		}
+
+		g.identityAdmin = c
+	}
+
+	return g.identityAdmin, nil
+}
+
+func (u *pooledUser) setTokens(idToken string, refreshToken string) error {
+	expiry, err := jwtExpiry(idToken)
+	if err != nil {
+		return err
+	}
+
+	u.idToken = idToken
+	u.refreshToken = refreshToken
+	u.expiry = expiry
+
+	return nil
+}
+
+// The function returns the end of the batch starting at n.
+func batchEnd(n int, total int) int {
+	if n+identityBatchSize < total {
+		return n + identityBatchSize
+	}
+
+	return total
+}
+
+// The function reserves a range of user numbers and returns the first one.
+func (p *identityPool) reserve(count int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	start := p.next
+	p.next += count
+
+	return start
+}
+
+func (p *identityPool) add(users []*pooledUser) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.users = append(p.users, users...)
+}
+
+// The function keeps the users of a failed provisioning aside, so that they are deleted with the others.
+func (p *identityPool) orphan(users []*pooledUser) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.orphans = append(p.orphans, users...)
+}
+
+// The function returns the user of a VU. VU IDs start at 1.
+func (p *identityPool) user(vu int) (*pooledUser, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if vu < 1 || vu > len(p.users) {
+		return nil, fmt.Errorf("no provisioned user for VU %d, %d users are provisioned", vu, len(p.users))
+	}
+
+	return p.users[vu-1], nil
+}
+
+// The function empties the pool and returns the users it held, including the orphaned ones.
+func (p *identityPool) drain() []*pooledUser {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	users := append(p.users, p.orphans...)
+	p.users = nil
+	p.orphans = nil
+
+	return users
+}
@@ -0,0 +1,155 @@
+package gcp
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdentitySignsInAndRefreshesThroughAuthEmulator(t *testing.T) {
+	idToken := func(uid string) string {
+		token, err := unsignedJwt(map[string]interface{}{"user_id": uid, "exp": time.Now().Add(time.Hour).Unix()}, firebaseEmulatorServiceAccount)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	var customToken string
+	emulator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") != firebaseEmulatorApiKey {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/identitytoolkit.googleapis.com/v1/accounts:signInWithCustomToken":
+			var body struct {
+				Token string `json:"token"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			customToken = body.Token
+
+			_ = json.NewEncoder(w).Encode(map[string]string{"idToken": idToken("user-1"), "refreshToken": "refresh-1", "expiresIn": "3600"})
+		case "/securetoken.googleapis.com/v1/token":
+			if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") != "refresh-1" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			_ = json.NewEncoder(w).Encode(map[string]string{"id_token": idToken("user-1"), "refresh_token": "refresh-2"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(emulator.Close)
+
+	g, err := newGcpConstructor(withGcpConstructorEmulators("", map[string]string{emulatorAuth: strings.TrimPrefix(emulator.URL, "http://")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	u := &pooledUser{uid: "user-1"}
+	if err := g.Identity.signIn(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := decodeJwt(customToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Header["alg"] != "none" || d.Payload["uid"] != "user-1" {
+		t.Errorf("expected an unsigned custom token of the user, got %v %v", d.Header, d.Payload)
+	}
+	if u.refreshToken != "refresh-1" || u.idToken == "" || time.Until(u.expiry) < 59*time.Minute {
+		t.Errorf("unexpected tokens after sign in: refresh token %s, expiry %s", u.refreshToken, u.expiry)
+	}
+
+	if err := g.Identity.refresh(ctx, u); err != nil {
+		t.Fatal(err)
+	}
+	if u.refreshToken != "refresh-2" {
+		t.Errorf("expected the refresh token to be rotated, got %s", u.refreshToken)
+	}
+}
+
+func TestDeleteUsersAfterFailedProvisioning(t *testing.T) {
+	tests := []struct {
+		name    string
+		failed  []int
+		signIn  int
+		deleted []string
+	}{
+		{"user not created", []int{1}, http.StatusOK, []string{"load-1", "load-3"}},
+		{"failed sign in", nil, http.StatusBadRequest, []string{"load-1", "load-2", "load-3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var deleted []string
+			emulator := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+
+				switch r.URL.Path {
+				case "/identitytoolkit.googleapis.com/v1/projects/p/accounts:batchCreate":
+					var errors []map[string]interface{}
+					for _, n := range tt.failed {
+						errors = append(errors, map[string]interface{}{"index": n, "message": "INVALID_EMAIL"})
+					}
+					_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": errors})
+				case "/identitytoolkit.googleapis.com/v1/accounts:signInWithCustomToken":
+					if tt.signIn != http.StatusOK {
+						w.WriteHeader(tt.signIn)
+						return
+					}
+					token, err := unsignedJwt(map[string]interface{}{"exp": time.Now().Add(time.Hour).Unix()}, firebaseEmulatorServiceAccount)
+					if err != nil {
+						t.Error(err)
+					}
+					_ = json.NewEncoder(w).Encode(map[string]string{"idToken": token, "refreshToken": "refresh", "expiresIn": "3600"})
+				case "/identitytoolkit.googleapis.com/v1/projects/p/accounts:batchDelete":
+					var body struct {
+						LocalIds []string `json:"localIds"`
+					}
+					if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+						t.Error(err)
+					}
+					deleted = append(deleted, body.LocalIds...)
+					_, _ = w.Write([]byte(`{}`))
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			t.Cleanup(emulator.Close)
+
+			g := newTestGcp(t,
+				withGcpConstructorEmulators("", map[string]string{emulatorAuth: strings.TrimPrefix(emulator.URL, "http://")}),
+				withGcpConstructorProjectId("p"),
+			)
+
+			if _, err := g.Identity.ProvisionUsers(3, IdentityUserTemplate{UidPrefix: "load-"}); err == nil {
+				t.Fatal("expected provisioning to fail")
+			}
+			if _, err := g.Identity.UserFor(1); err == nil {
+				t.Error("expected no user to be handed to VUs")
+			}
+
+			n, err := g.Identity.DeleteUsers()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(tt.deleted) || !reflect.DeepEqual(deleted, tt.deleted) {
+				t.Errorf("expected users %v to be deleted, got %d users %v", tt.deleted, n, deleted)
+			}
+		})
+	}
+}
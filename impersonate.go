@@ -0,0 +1,176 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	iamcredentials "google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/option"
+)
+
+const (
+	// Service name of the client options of IAM Credentials
+	iamCredentialsService = "iamcredentials"
+	// Token lifetime requested from IAM Credentials. Tokens are refreshed automatically once expired.
+	impersonatedTokenLifetime = "3600s"
+)
+
+// The token source mints access tokens for the impersonated service account through the IAM
+// Credentials generateAccessToken endpoint.
+type impersonatedTokenSource struct {
+	ctx       context.Context
+	service   *iamcredentials.Service
+	name      string
+	delegates []string
+	scope     []string
+}
+
+func (i impersonatedTokenSource) Token() (*oauth2.Token, error) {
+	req := &iamcredentials.GenerateAccessTokenRequest{
+		Delegates: i.delegates,
+		Lifetime:  impersonatedTokenLifetime,
+		Scope:     i.scope,
+	}
+
+	res, err := i.service.Projects.ServiceAccounts.GenerateAccessToken(i.name, req).Context(i.ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate access token for %s <%w>", i.name, err)
+	}
+
+	expiry, err := time.Parse(time.RFC3339, res.ExpireTime)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse access token expiry %s <%w>", res.ExpireTime, err)
+	}
+
+	return &oauth2.Token{
+		AccessToken: res.AccessToken,
+		TokenType:   "Bearer",
+		Expiry:      expiry,
+	}, nil
+}
+
+// The token source mints ID tokens for the impersonated service account through the IAM Credentials
+// generateIdToken endpoint.
+type impersonatedIdTokenSource struct {
+	ctx          context.Context
+	service      *iamcredentials.Service
+	name         string
+	delegates    []string
+	audience     string
+	includeEmail bool
+}
+
+func (i impersonatedIdTokenSource) Token() (*oauth2.Token, error) {
+	req := &iamcredentials.GenerateIdTokenRequest{
+		Audience:     i.audience,
+		Delegates:    i.delegates,
+		IncludeEmail: i.includeEmail,
+	}
+
+	res, err := i.service.Projects.ServiceAccounts.GenerateIdToken(i.name, req).Context(i.ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate ID token for %s <%w>", i.name, err)
+	}
+
+	expiry, err := jwtExpiry(res.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &oauth2.Token{
+		AccessToken: res.Token,
+		TokenType:   "Bearer",
+		Expiry:      expiry,
+	}, nil
+}
+
+// This is a method of the `Gcp` struct that returns the IAM Credentials client used to impersonate
+// the configured service account and to sign blobs and JWTs, created once per instance and bound to its
+// lifetime. The base credentials always use the cloud-platform scope since the IAM Credentials API
+// requires it.
+func (g *Gcp) iamCredentialsClient() (*iamcredentials.Service, error) {
+	g.iamCredentialsMu.Lock()
+	defer g.iamCredentialsMu.Unlock()
+
+	if g.iamCredentials != nil {
+		return g.iamCredentials, nil
+	}
+
+	base, err := g.credentialsTokenSource(g.lifetime, gcpConstructorDefaultScope)
+	if err != nil {
+		return nil, err
+	}
+
+	o := g.serviceClientOptions(iamCredentialsService)
+	options := append(o.options(), option.WithTokenSource(base))
+
+	hc, err := g.restHttpClient(g.lifetime, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := iamcredentials.NewService(g.lifetime, o.restOptions(hc)...)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize IAM Credentials client <%w>", err)
+	}
+	s.UserAgent = o.UserAgent
+	g.iamCredentials = s
+
+	return s, nil
+}
+
+// This is a method of the `Gcp` struct that returns a token source minting access tokens for the
+// impersonated service account with the given scopes.
+func (g *Gcp) impersonatedTokenSource(ctx context.Context, scope []string) (oauth2.TokenSource, error) {
+	s, err := g.iamCredentialsClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return impersonatedTokenSource{
+		ctx:       ctx,
+		service:   s,
+		name:      serviceAccountResourceName(g.impersonateServiceAccount),
+		delegates: g.delegateResourceNames(),
+		scope:     scope,
+	}, nil
+}
+
+// This is a method of the `Gcp` struct that returns a token source minting ID tokens for the
+// impersonated service account with the given audience.
+func (g *Gcp) impersonatedIdTokenSource(ctx context.Context, audience string, includeEmail bool) (oauth2.TokenSource, error) {
+	s, err := g.iamCredentialsClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return impersonatedIdTokenSource{
+		ctx:          ctx,
+		service:      s,
+		name:         serviceAccountResourceName(g.impersonateServiceAccount),
+		delegates:    g.delegateResourceNames(),
+		audience:     audience,
+		includeEmail: includeEmail,
+	}, nil
+}
+
+func (g *Gcp) delegateResourceNames() []string {
+	var delegates []string
+	for _, d := range g.delegates {
+		delegates = append(delegates, serviceAccountResourceName(d))
+	}
+
+	return delegates
+}
+
+// The function returns the IAM resource name of a service account email.
+func serviceAccountResourceName(email string) string {
+	if strings.HasPrefix(email, "projects/") {
+		return email
+	}
+
+	return "projects/-/serviceAccounts/" + email
+}
@@ -0,0 +1,110 @@
+package gcp
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestImpersonatedTokensThroughIamCredentials(t *testing.T) {
+	const (
+		target   = "target@p.iam.gserviceaccount.com"
+		delegate = "delegate@p.iam.gserviceaccount.com"
+		audience = "https://service.run.app"
+	)
+
+	idToken, err := unsignedJwt(map[string]interface{}{"aud": audience, "exp": time.Now().Add(time.Hour).Unix()}, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type request struct {
+		Delegates    []string `json:"delegates"`
+		Scope        []string `json:"scope"`
+		Lifetime     string   `json:"lifetime"`
+		Audience     string   `json:"audience"`
+		IncludeEmail bool     `json:"includeEmail"`
+	}
+	requests := make(map[string]request)
+	iam := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// IAM Credentials is called with the token of the base credentials
+		if a := r.Header.Get("Authorization"); a != "Bearer token-1" {
+			t.Errorf("expected IAM Credentials to be called with the base token, got %s", a)
+		}
+
+		var body request
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		requests[r.URL.Path] = body
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/projects/-/serviceAccounts/" + target + ":generateAccessToken":
+			_ = json.NewEncoder(w).Encode(map[string]string{"accessToken": "impersonated-token", "expireTime": time.Now().Add(time.Hour).Format(time.RFC3339)})
+		case "/v1/projects/-/serviceAccounts/" + target + ":generateIdToken":
+			_ = json.NewEncoder(w).Encode(map[string]string{"token": idToken})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(iam.Close)
+
+	var authorization string
+	sheets := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		writeValues(t, w, [][]interface{}{{"id"}})
+	}))
+	t.Cleanup(sheets.Close)
+
+	tokens, _ := tokenServer(t)
+	g := newTestGcp(t,
+		withGcpConstructorScope([]string{"https://www.googleapis.com/auth/pubsub"}),
+		withGcpConstructorKey(testServiceAccountKey(t, tokens.URL), ""),
+		withGcpConstructorImpersonation(target, []string{delegate}),
+		withGcpConstructorClientOptions(GcpClientOptions{Services: map[string]GcpClientOptions{
+			iamCredentialsService: {Endpoint: iam.URL + "/"},
+			sheetsService:         {Endpoint: sheets.URL + "/"},
+		}}),
+	)
+
+	// PubSub clients are authorized with the token source of the configured scope
+	ts, err := g.tokenSource(g.scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.AccessToken != "impersonated-token" {
+		t.Errorf("expected the token minted for %s, got %s", target, token.AccessToken)
+	}
+
+	if _, err := g.SpreadsheetGet("sheet-id", "Users", "A:A", CallOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "Bearer impersonated-token" {
+		t.Errorf("expected Sheets to be called with the minted token, got %s", authorization)
+	}
+
+	id, err := g.GetOAuth2IdToken(audience, IdTokenOptions{IncludeEmail: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.AccessToken != idToken {
+		t.Errorf("expected the minted ID token, got %s", id.AccessToken)
+	}
+
+	delegates := []string{"projects/-/serviceAccounts/" + delegate}
+	expected := map[string]request{
+		"/v1/projects/-/serviceAccounts/" + target + ":generateAccessToken": {Delegates: delegates, Scope: []string{"https://www.googleapis.com/auth/pubsub"}, Lifetime: impersonatedTokenLifetime},
+		"/v1/projects/-/serviceAccounts/" + target + ":generateIdToken":     {Delegates: delegates, Audience: audience, IncludeEmail: true},
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("expected requests %+v, got %+v", expected, requests)
+	}
+}
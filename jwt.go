@@ -0,0 +1,442 @@
+package gcp
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	googleJwksUrl   = "https://www.googleapis.com/oauth2/v3/certs"
+	firebaseJwksUrl = "https://www.googleapis.com/service_accounts/v1/jwk/securetoken@system.gserviceaccount.com"
+	firebaseIssuer  = "https://securetoken.google.com/"
+
+	// JWKS are refetched after this long unless the response sets a max-age
+	defaultJwksMaxAge = time.Hour
+	// A JWKS is refetched for an unknown key at most this often, since signing keys only rotate a few
+	// times a day
+	jwksRefetchInterval = time.Minute
+	// Maximum duration of a JWKS fetch, which is shared by every VU waiting for it
+	jwksFetchTimeout = 30 * time.Second
+	// Tolerated clock skew when checking `exp`, `nbf` and `iat`
+	jwtLeeway = 30 * time.Second
+)
+
+var googleIssuers = []string{"accounts.google.com", "https://accounts.google.com"}
+
+type (
+	// Options of `VerifyIdToken`
+	VerifyIdTokenOptions struct {
+		// Expected `aud` claim, required
+		Audience string
+		// Keys the token is verified against, defaults to the Google OAuth2 certificates
+		JwksUrl string
+		// Accepted `iss` claims. Defaults to the Google issuers, or the Firebase issuer of the audience
+		// when verifying against the Firebase JWKS. Required for any other JWKS.
+		Issuer []string
+		// Maximum time to wait for the JWKS, e.g. `10s`
+		Timeout string
+	}
+
+	// Unverified content of a JWT returned by `DecodeJwt`
+	DecodedJwt struct {
+		Header  map[string]interface{}
+		Payload map[string]interface{}
+	}
+
+	// Process-wide cache of JSON Web Key Sets keyed by URL.
+	jwksCache struct {
+		mu      sync.Mutex
+		entries map[string]*jwksEntry
+	}
+
+	// JWKS of an URL, guarded by its own lock so that fetching a JWKS never holds up the others
+	jwksEntry struct {
+		mu  sync.Mutex
+		set *jwks
+		// Fetch in flight, shared by every caller, and the time the last one started
+		fetch     *jwksFetch
+		fetchedAt time.Time
+	}
+
+	jwksFetch struct {
+		done chan struct{}
+		set  *jwks
+		err  error
+	}
+
+	jwks struct {
+		keys    map[string]crypto.PublicKey
+		expires time.Time
+	}
+
+	jsonWebKey struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		Use string `json:"use"`
+		Crv string `json:"crv"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+		X   string `json:"x"`
+		Y   string `json:"y"`
+	}
+)
+
+// This is a method of the `Gcp` struct that decodes a JWT without verifying it, e.g. to assert on the
+// claims of a token inside checks.
+func (g *Gcp) DecodeJwt(token string) (*DecodedJwt, error) {
+	return decodeJwt(token)
+}
+
+// This is a method of the `Gcp` struct that verifies a Google- or Firebase-signed token and returns its
+// claims. The signature is checked against the keys of the JWKS, which are cached for the whole
+// process, along with the `exp`, `iss` and `aud` claims.
+func (g *Gcp) VerifyIdToken(token string, options VerifyIdTokenOptions) (map[string]interface{}, error) {
+	if options.Audience == "" {
+		return nil, fmt.Errorf("an audience is required to verify an ID token")
+	}
+
+	jwksUrl := options.JwksUrl
+	if jwksUrl == "" {
+		jwksUrl = googleJwksUrl
+	}
+
+	issuers := options.Issuer
+	if len(issuers) == 0 {
+		switch jwksUrl {
+		case googleJwksUrl:
+			issuers = googleIssuers
+		case firebaseJwksUrl:
+			issuers = []string{firebaseIssuer + options.Audience}
+		default:
+			return nil, fmt.Errorf("an issuer is required to verify an ID token against %s", jwksUrl)
+		}
+	}
+
+	d, err := decodeJwt(token)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel, err := g.callContext(options.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	kid, _ := d.Header["kid"].(string)
+	key, err := g.jwks.key(ctx, g.networkTransport(), jwksUrl, kid)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyJwtSignature(token, d.Header["alg"], key); err != nil {
+		return nil, err
+	}
+
+	if err := verifyJwtClaims(d.Payload, options.Audience, issuers); err != nil {
+		return nil, err
+	}
+
+	return d.Payload, nil
+}
+
+// The function decodes the header and payload of a JWT.
+func decodeJwt(token string) (*DecodedJwt, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed JWT, expected 3 segments but got %d", len(parts))
+	}
+
+	d := &DecodedJwt{}
+	for i, v := range []*map[string]interface{}{&d.Header, &d.Payload} {
+		b, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode JWT segment %d <%w>", i, err)
+		}
+
+		if err := json.Unmarshal(b, v); err != nil {
+			return nil, fmt.Errorf("unable to unmarshal JWT segment %d <%w>", i, err)
+		}
+	}
+
+	return d, nil
+}
+
+// The function reads the `exp` claim of a JWT without verifying its signature.
+func jwtExpiry(token string) (time.Time, error) {
+	d, err := decodeJwt(token)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	exp, _ := d.Payload["exp"].(float64)
+
+	return time.Unix(int64(exp), 0), nil
+}
+
+func verifyJwtSignature(token string, alg interface{}, key crypto.PublicKey) error {
+	i := strings.LastIndex(token, ".")
+	signature, err := base64.RawURLEncoding.DecodeString(token[i+1:])
+	if err != nil {
+		return fmt.Errorf("unable to decode JWT signature <%w>", err)
+	}
+	h := sha256.Sum256([]byte(token[:i]))
+
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return fmt.Errorf("unexpected JWT algorithm %v for an RSA key", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, crypto.SHA256, h[:], signature); err != nil {
+			return fmt.Errorf("invalid JWT signature <%w>", err)
+		}
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || len(signature) != 64 {
+			return fmt.Errorf("unexpected JWT algorithm %v for an EC key", alg)
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(k, h[:], r, s) {
+			return fmt.Errorf("invalid JWT signature")
+		}
+	default:
+		return fmt.Errorf("unsupported JWT key type %T", key)
+	}
+
+	return nil
+}
+
+func verifyJwtClaims(claims map[string]interface{}, audience string, issuers []string) error {
+	now := time.Now()
+
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("JWT has no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(jwtLeeway)) {
+		return fmt.Errorf("JWT expired at %s", time.Unix(int64(exp), 0).UTC())
+	}
+
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(jwtLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return fmt.Errorf("JWT is not valid before %s", time.Unix(int64(nbf), 0).UTC())
+	}
+
+	iss, _ := claims["iss"].(string)
+	if !containsString(issuers, iss) {
+		return fmt.Errorf("unexpected JWT issuer %s, expected one of %s", iss, issuers)
+	}
+
+	var auds []string
+	switch aud := claims["aud"].(type) {
+	case string:
+		auds = []string{aud}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok {
+				auds = append(auds, s)
+			}
+		}
+	}
+	if !containsString(auds, audience) {
+		return fmt.Errorf("unexpected JWT audience %s, expected %s", auds, audience)
+	}
+
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func newJwksCache() *jwksCache {
+	return &jwksCache{
+		entries: make(map[string]*jwksEntry),
+	}
+}
+
+// The function returns the key of a JWKS with the given ID. The JWKS is refetched when it expired, or when
+// the key is unknown since signing keys are rotated, at most once per `jwksRefetchInterval`. Callers
+// asking for a JWKS being fetched wait for that fetch.
+func (c *jwksCache) key(ctx context.Context, transport http.RoundTripper, url string, kid string) (crypto.PublicKey, error) {
+	c.mu.Lock()
+	e, ok := c.entries[url]
+	if !ok {
+		e = &jwksEntry{}
+		c.entries[url] = e
+	}
+	c.mu.Unlock()
+
+	e.mu.Lock()
+	fresh := e.set != nil && time.Now().Before(e.set.expires)
+	if fresh {
+		if key, ok := e.set.keys[kid]; ok {
+			e.mu.Unlock()
+			return key, nil
+		}
+	}
+
+	f := e.fetch
+	if f == nil {
+		if fresh && time.Since(e.fetchedAt) < jwksRefetchInterval {
+			e.mu.Unlock()
+			return nil, fmt.Errorf("no key %s found in JWKS %s", kid, url)
+		}
+
+		f = &jwksFetch{done: make(chan struct{})}
+		e.fetch = f
+		e.fetchedAt = time.Now()
+		go e.refresh(f, transport, url)
+	}
+	e.mu.Unlock()
+
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return nil, fmt.Errorf("unable to fetch JWKS %s <%w>", url, ctx.Err())
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	key, ok := f.set.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("no key %s found in JWKS %s", kid, url)
+	}
+
+	return key, nil
+}
+
+// The function fetches the JWKS of the entry on behalf of every caller waiting for it, so the fetch is
+// bounded by its own timeout rather than by the context of one of them. A failed fetch keeps the
+// previous JWKS.
+func (e *jwksEntry) refresh(f *jwksFetch, transport http.RoundTripper, url string) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksFetchTimeout)
+	defer cancel()
+
+	f.set, f.err = fetchJwks(ctx, transport, url)
+
+	e.mu.Lock()
+	if f.err == nil {
+		e.set = f.set
+	}
+	e.fetch = nil
+	e.mu.Unlock()
+
+	close(f.done)
+}
+
+// The function fetches a JWKS. Keys the module cannot verify signatures with, e.g. encryption keys or
+// keys of other curves, are skipped.
+func fetchJwks(ctx context.Context, transport http.RoundTripper, url string) (*jwks, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch JWKS %s <%w>", url, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch JWKS %s, status %d", url, res.StatusCode)
+	}
+
+	var body struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("unable to unmarshal JWKS %s <%w>", url, err)
+	}
+
+	set := &jwks{
+		keys:    make(map[string]crypto.PublicKey),
+		expires: time.Now().Add(jwksMaxAge(res.Header.Get("Cache-Control"))),
+	}
+	for _, k := range body.Keys {
+		if k.Use == "enc" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			continue
+		}
+		set.keys[k.Kid] = key
+	}
+
+	return set, nil
+}
+
+// The function reads the max-age directive of a Cache-Control header.
+func jwksMaxAge(cacheControl string) time.Duration {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		if v, ok := strings.CutPrefix(directive, "max-age="); ok {
+			if seconds, err := strconv.Atoi(v); err == nil {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	return defaultJwksMaxAge
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+	}
+}
@@ -0,0 +1,281 @@
+package gcp
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestVerifyJwtClaims(t *testing.T) {
+	now := float64(time.Now().Unix())
+	issuers := []string{"https://accounts.google.com"}
+
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		ok     bool
+	}{
+		{"valid", map[string]interface{}{"exp": now + 60, "iss": issuers[0], "aud": "my-audience"}, true},
+		{"audience array", map[string]interface{}{"exp": now + 60, "iss": issuers[0], "aud": []interface{}{"other", "my-audience"}}, true},
+		{"expired within leeway", map[string]interface{}{"exp": now - 10, "iss": issuers[0], "aud": "my-audience"}, true},
+		{"expired", map[string]interface{}{"exp": now - 60, "iss": issuers[0], "aud": "my-audience"}, false},
+		{"no exp", map[string]interface{}{"iss": issuers[0], "aud": "my-audience"}, false},
+		{"not yet valid", map[string]interface{}{"exp": now + 120, "nbf": now + 60, "iss": issuers[0], "aud": "my-audience"}, false},
+		{"unexpected issuer", map[string]interface{}{"exp": now + 60, "iss": "https://example.com", "aud": "my-audience"}, false},
+		{"unexpected audience", map[string]interface{}{"exp": now + 60, "iss": issuers[0], "aud": "other"}, false},
+		{"no audience", map[string]interface{}{"exp": now + 60, "iss": issuers[0]}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyJwtClaims(tt.claims, "my-audience", issuers)
+			if tt.ok && err != nil {
+				t.Fatalf("expected claims to be valid, got %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected claims to be rejected")
+			}
+		})
+	}
+}
+
+func TestJwksMaxAge(t *testing.T) {
+	tests := []struct {
+		cacheControl string
+		expected     time.Duration
+	}{
+		{"", defaultJwksMaxAge},
+		{"public, max-age=300, must-revalidate", 300 * time.Second},
+		{"max-age=0", 0},
+		{"max-age=soon", defaultJwksMaxAge},
+		{"no-cache", defaultJwksMaxAge},
+	}
+
+	for _, tt := range tests {
+		if d := jwksMaxAge(tt.cacheControl); d != tt.expected {
+			t.Errorf("jwksMaxAge(%q) = %s, expected %s", tt.cacheControl, d, tt.expected)
+		}
+	}
+}
+
+func TestJwksCacheVerifiesSignatures(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server, _ := jwksServer(t, rsaKey, ecKey)
+	cache := newJwksCache()
+
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"RS256", signTestJwt(t, "RS256", "rsa", rsaKey), true},
+		{"ES256", signTestJwt(t, "ES256", "ec", ecKey), true},
+		{"tampered", signTestJwt(t, "RS256", "rsa", rsaKey) + "A", false},
+		{"algorithm mismatch", signTestJwt(t, "ES256", "rsa", ecKey), false},
+		{"signed with another key", signTestJwt(t, "RS256", "rsa", mustRsaKey(t)), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := decodeJwt(tt.token)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			key, err := cache.key(context.Background(), http.DefaultTransport, server.URL, d.Header["kid"].(string))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = verifyJwtSignature(tt.token, d.Header["alg"], key)
+			if tt.ok && err != nil {
+				t.Fatalf("expected signature to be valid, got %v", err)
+			}
+			if !tt.ok && err == nil {
+				t.Fatal("expected signature to be rejected")
+			}
+		})
+	}
+}
+
+func TestJwksCacheSkipsUnsupportedKeys(t *testing.T) {
+	server, _ := jwksServer(t, mustRsaKey(t), nil)
+
+	set, err := fetchJwks(context.Background(), http.DefaultTransport, server.URL)
+	if err != nil {
+		t.Fatalf("expected unsupported keys to be skipped, got %v", err)
+	}
+
+	for _, kid := range []string{"oct", "p384", "enc"} {
+		if _, ok := set.keys[kid]; ok {
+			t.Errorf("expected key %s to be skipped", kid)
+		}
+	}
+	if _, ok := set.keys["rsa"]; !ok {
+		t.Error("expected key rsa to be kept")
+	}
+}
+
+func TestJwksCacheRateLimitsRefetches(t *testing.T) {
+	server, fetches := jwksServer(t, mustRsaKey(t), nil)
+	cache := newJwksCache()
+	ctx := context.Background()
+
+	if _, err := cache.key(ctx, http.DefaultTransport, server.URL, "rsa"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := cache.key(ctx, http.DefaultTransport, server.URL, "unknown"); err == nil {
+			t.Fatal("expected unknown key to be rejected")
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("expected unknown keys to be refetched at most once per interval, got %d fetches", n)
+	}
+
+	cache.entries[server.URL].fetchedAt = time.Now().Add(-jwksRefetchInterval)
+	if _, err := cache.key(ctx, http.DefaultTransport, server.URL, "unknown"); err == nil {
+		t.Fatal("expected unknown key to be rejected")
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Fatalf("expected unknown key to be refetched once the interval elapsed, got %d fetches", n)
+	}
+}
+
+func TestJwksCacheSharesFetches(t *testing.T) {
+	key := mustRsaKey(t)
+	release := make(chan struct{})
+
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		writeJwks(t, w, map[string]interface{}{"kid": "rsa", "kty": "RSA", "n": b64(key.N.Bytes()), "e": b64(big.NewInt(int64(key.E)).Bytes())})
+	}))
+	t.Cleanup(server.Close)
+
+	cache := newJwksCache()
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.key(context.Background(), http.DefaultTransport, server.URL, "rsa")
+			errs <- err
+		}()
+	}
+
+	// Give every caller the time to join the fetch in flight
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("expected concurrent callers to share one fetch, got %d fetches", n)
+	}
+}
+
+// The function serves a JWKS holding the given keys along with keys the module does not support, and
+// counts its fetches.
+func jwksServer(t *testing.T, rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+
+	keys := []interface{}{
+		map[string]interface{}{"kid": "rsa", "kty": "RSA", "use": "sig", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		map[string]interface{}{"kid": "enc", "kty": "RSA", "use": "enc", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		map[string]interface{}{"kid": "oct", "kty": "oct", "k": b64([]byte("secret"))},
+		map[string]interface{}{"kid": "p384", "kty": "EC", "crv": "P-384", "x": b64([]byte{1}), "y": b64([]byte{2})},
+	}
+	if ecKey != nil {
+		keys = append(keys, map[string]interface{}{"kid": "ec", "kty": "EC", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())})
+	}
+
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		writeJwks(t, w, keys...)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &fetches
+}
+
+func writeJwks(t *testing.T, w http.ResponseWriter, keys ...interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys}); err != nil {
+		t.Error(err)
+	}
+}
+
+// The function signs a token with the given algorithm, key ID and private key.
+func signTestJwt(t *testing.T, alg string, kid string, key crypto.Signer) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(map[string]interface{}{"sub": "test", "exp": time.Now().Add(time.Hour).Unix()})
+	unsigned := b64(header) + "." + b64(payload)
+	h := sha256.Sum256([]byte(unsigned))
+
+	var signature []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, h[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, h[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	}
+
+	return unsigned + "." + b64(signature)
+}
+
+func mustRsaKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
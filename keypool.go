@@ -0,0 +1,149 @@
+package gcp
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync/atomic"
+
+	"go.k6.io/k6/js/modules"
+)
+
+const (
+	// Cycles through the keys on every call
+	keySelectionRoundRobin = "round_robin"
+	// Pins each VU to one key
+	keySelectionSticky = "sticky"
+	// Picks a key at random on every call
+	keySelectionRandom = "random"
+)
+
+// Pool of instances, one per service account key of `GcpConfig.Key`, so that calls spread over the
+// per-identity quotas of several service accounts.
+type keyPool struct {
+	members   []*Gcp
+	selection string
+	next      atomic.Uint64
+	// Key the VU is pinned to by the sticky strategy
+	sticky int
+}
+
+// This is a method of the `Gcp` struct that returns the instance a call is made with: the instance of the
+// selected profile, or the instance of the key picked from its key pool.
+func (g *Gcp) resolve(profile string) (*Gcp, error) {
+	p := g
+	if profile != "" {
+		var ok bool
+		if p, ok = g.profiles[profile]; !ok {
+			return nil, fmt.Errorf("unknown profile %s", profile)
+		}
+	}
+
+	if p.keys == nil {
+		return p, nil
+	}
+
+	m := p.keys.members[p.keys.pick()]
+	p.pushMetric(p.metrics.KeySelections, 1, map[string]string{"key": m.keyName})
+
+	return m, nil
+}
+
+// The function returns the index of the key selected by the strategy of the pool.
+func (k *keyPool) pick() int {
+	n := uint64(len(k.members))
+
+	switch k.selection {
+	case keySelectionSticky:
+		return k.sticky
+	case keySelectionRandom:
+		return rand.Intn(len(k.members))
+	default:
+		return int((k.next.Add(1) - 1) % n)
+	}
+}
+
+// The function reads the `key` parameter, either a single credentials JSON or an array of them.
+func credentialKeys(key interface{}) ([]map[string]interface{}, error) {
+	switch k := key.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		if len(k) == 0 {
+			return nil, nil
+		}
+
+		return []map[string]interface{}{k}, nil
+	case []interface{}:
+		keys := make([]map[string]interface{}, 0, len(k))
+		for i, v := range k {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("key %d is not a credentials JSON object", i)
+			}
+			keys = append(keys, m)
+		}
+
+		return keys, nil
+	default:
+		return nil, fmt.Errorf("key must be a credentials JSON object or an array of them")
+	}
+}
+
+func withGcpConstructorKeyPool(mi *ModuleInstance, config GcpConfig, keys []map[string]interface{}) func(*Gcp) error {
+	return func(g *Gcp) error {
+		switch config.KeySelection {
+		case "", keySelectionRoundRobin, keySelectionSticky, keySelectionRandom:
+		default:
+			return fmt.Errorf("unknown key_selection %s, expected %s, %s or %s", config.KeySelection, keySelectionRoundRobin, keySelectionSticky, keySelectionRandom)
+		}
+
+		if len(keys) < 2 {
+			return nil
+		}
+
+		pool := &keyPool{selection: config.KeySelection}
+		for i, key := range keys {
+			c := config
+			c.Key = key
+			c.KeySelection = ""
+			c.Profiles = nil
+
+			m, err := mi.gcpFromConfig(c)
+			if err != nil {
+				return fmt.Errorf("invalid key %d <%w>", i, err)
+			}
+
+			m.keyName = strconv.Itoa(i)
+			if sa, ok := m.key.(*ServiceAccountKey); ok && sa.ClientEmail != "" {
+				m.keyName = sa.ClientEmail
+			}
+
+			pool.members = append(pool.members, m)
+		}
+		if id := vuId(mi.vu); id > 0 {
+			pool.sticky = int((id - 1) % uint64(len(pool.members)))
+		}
+		g.keys = pool
+
+		return nil
+	}
+}
+
+// The function returns the ID of the VU, read from the `__VU` global in the init context where the VU has
+// no state yet. It is 0 for the VU k6 reads the options of the script with.
+func vuId(vu modules.VU) uint64 {
+	if vu == nil {
+		return 0
+	}
+	if state := vu.State(); state != nil {
+		return state.VUID
+	}
+
+	v := vu.Runtime().Get("__VU")
+	if v == nil || v.ToInteger() < 0 {
+		return 0
+	}
+
+	return uint64(v.ToInteger())
+}
@@ -0,0 +1,80 @@
+package gcp
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestKeyPoolSelection(t *testing.T) {
+	tests := []struct {
+		name      string
+		selection string
+		expected  []string
+	}{
+		{"round robin", keySelectionRoundRobin, []string{"sa-0", "sa-1", "sa-2", "sa-0", "sa-1", "sa-2"}},
+		{"default", "", []string{"sa-0", "sa-1", "sa-2", "sa-0"}},
+		// VU 5 is pinned to the key (5 - 1) % 3
+		{"sticky", keySelectionSticky, []string{"sa-1", "sa-1", "sa-1", "sa-1"}},
+		{"random", keySelectionRandom, make([]string, 50)},
+	}
+
+	var keys []interface{}
+	for i := 0; i < 3; i++ {
+		key := testServiceAccountKey(t, "http://localhost/token")
+		key["client_email"] = fmt.Sprintf("sa-%d@p.iam.gserviceaccount.com", i)
+		keys = append(keys, key)
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mi, samples := newTestModuleInstance(t)
+			mi.vu.State().VUID = 5
+
+			g, err := mi.gcpFromConfig(GcpConfig{Key: keys, KeySelection: tt.selection})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var picked []string
+			for range tt.expected {
+				m, err := g.resolve("")
+				if err != nil {
+					t.Fatal(err)
+				}
+				picked = append(picked, m.keyName)
+			}
+
+			selections := collectSamples(samples)
+			if len(selections) != len(picked) {
+				t.Fatalf("expected a gcp_key_selections sample per call, got %d", len(selections))
+			}
+			seen := make(map[string]bool)
+			for i, s := range selections {
+				if s.Metric.Name != "gcp_key_selections" {
+					t.Fatalf("expected gcp_key_selections samples, got %s", s.Metric.Name)
+				}
+				if key, _ := s.Tags.Get("key"); key != picked[i] {
+					t.Errorf("expected selection %d to be tagged with key %s, got %s", i, picked[i], key)
+				}
+				seen[picked[i]] = true
+			}
+
+			if tt.selection == keySelectionRandom {
+				for key := range seen {
+					if key != "sa-0@p.iam.gserviceaccount.com" && key != "sa-1@p.iam.gserviceaccount.com" && key != "sa-2@p.iam.gserviceaccount.com" {
+						t.Errorf("expected a key of the pool, got %s", key)
+					}
+				}
+				if len(seen) < 2 {
+					t.Errorf("expected random selections to spread over the keys, got %v", seen)
+				}
+				return
+			}
+			for i, key := range picked {
+				if key != tt.expected[i]+"@p.iam.gserviceaccount.com" {
+					t.Errorf("expected call %d to pick %s, got %s", i, tt.expected[i], key)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,279 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go.k6.io/k6/metrics"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/stats"
+)
+
+type (
+	gcpMetrics struct {
+		TokenCacheHits   *metrics.Metric
+		TokenCacheMisses *metrics.Metric
+		KeySelections    *metrics.Metric
+
+		Requests        *metrics.Metric
+		RequestDuration *metrics.Metric
+		RequestFailed   *metrics.Metric
+		BytesSent       *metrics.Metric
+		BytesReceived   *metrics.Metric
+		Retries         *metrics.Metric
+	}
+
+	// A call to a Google API, emitted as the `gcp_request*` and `gcp_bytes_*` metrics once it ends
+	gcpRequest struct {
+		g        *Gcp
+		service  string
+		method   string
+		resource string
+		start    time.Time
+		// Key of the key pool the call is made with, if any
+		key string
+		// Instance the call is made with once resolved, e.g. the one of a profile
+		via *Gcp
+		// Retry policy of the calls made through `retry`
+		retry retryPolicy
+		// Span of the call, started along with its context
+		span trace.Span
+		// Payload bytes of the call, counted by the transports of the clients when the call context holds
+		// them
+		bytes *byteCounter
+	}
+
+	byteCounter struct {
+		sent     atomic.Int64
+		received atomic.Int64
+	}
+
+	gcpRequestKey struct{}
+
+	// HTTP transport of REST clients counting the payload bytes of the call the request belongs to
+	countingTransport struct {
+		base http.RoundTripper
+	}
+
+	countingBody struct {
+		io.ReadCloser
+		bytes *byteCounter
+	}
+
+	// gRPC stats handler counting the payload bytes of the call the RPC belongs to
+	countingStatsHandler struct{}
+)
+
+// The function registers the custom metrics of the module. The registry hands out the same metric when
+// it is registered again with the same type, so every VU can call it.
+func registerMetrics(registry *metrics.Registry) (*gcpMetrics, error) {
+	var err error
+	m := &gcpMetrics{}
+
+	if m.TokenCacheHits, err = registry.NewMetric("gcp_token_cache_hits", metrics.Counter); err != nil {
+		return nil, fmt.Errorf("unable to register gcp_token_cache_hits metric <%w>", err)
+	}
+
+	if m.TokenCacheMisses, err = registry.NewMetric("gcp_token_cache_misses", metrics.Counter); err != nil {
+		return nil, fmt.Errorf("unable to register gcp_token_cache_misses metric <%w>", err)
+	}
+
+	if m.KeySelections, err = registry.NewMetric("gcp_key_selections", metrics.Counter); err != nil {
+		return nil, fmt.Errorf("unable to register gcp_key_selections metric <%w>", err)
+	}
+
+	if m.Requests, err = registry.NewMetric("gcp_requests", metrics.Counter); err != nil {
+		return nil, fmt.Errorf("unable to register gcp_requests metric <%w>", err)
+	}
+
+	if m.RequestDuration, err = registry.NewMetric("gcp_request_duration", metrics.Trend, metrics.Time); err != nil {
+		return nil, fmt.Errorf("unable to register gcp_request_duration metric <%w>", err)
+	}
+
+	if m.RequestFailed, err = registry.NewMetric("gcp_request_failed", metrics.Rate); err != nil {
+		return nil, fmt.Errorf("unable to register gcp_request_failed metric <%w>", err)
+	}
+
+	if m.BytesSent, err = registry.NewMetric("gcp_bytes_sent", metrics.Counter, metrics.Data); err != nil {
+		return nil, fmt.Errorf("unable to register gcp_bytes_sent metric <%w>", err)
+	}
+
+	if m.BytesReceived, err = registry.NewMetric("gcp_bytes_received", metrics.Counter, metrics.Data); err != nil {
+		return nil, fmt.Errorf("unable to register gcp_bytes_received metric <%w>", err)
+	}
+
+	if m.Retries, err = registry.NewMetric("gcp_retries", metrics.Counter); err != nil {
+		return nil, fmt.Errorf("unable to register gcp_retries metric <%w>", err)
+	}
+
+	return m, nil
+}
+
+// This is a method of the `Gcp` struct that emits a sample of a metric tagged with the current VU tags
+// and the given ones. Samples are dropped in the init context, where there is no VU state.
+func (g *Gcp) pushMetric(m *metrics.Metric, value float64, tags map[string]string) {
+	if g.vu == nil || m == nil {
+		return
+	}
+
+	state := g.vu.State()
+	if state == nil {
+		return
+	}
+
+	ctm := state.Tags.GetCurrentValues()
+	metrics.PushIfNotDone(g.vu.Context(), state.Samples, metrics.Sample{
+		TimeSeries: metrics.TimeSeries{
+			Metric: m,
+			Tags:   ctm.Tags.WithTagsFromMap(tags),
+		},
+		Time:     time.Now(),
+		Value:    value,
+		Metadata: ctm.Metadata,
+	})
+}
+
+// This is a method of the `Gcp` struct that starts measuring a call of a service method on a resource,
+// e.g. a topic, a spreadsheet or a project.
+func (g *Gcp) startRequest(service string, method string, resource string) *gcpRequest {
+	return &gcpRequest{
+		g:        g,
+		service:  service,
+		method:   method,
+		resource: resource,
+		start:    time.Now(),
+	}
+}
+
+// The function binds the call to the instance it is made with once resolved: the call is retried with its
+// retry policy, overridden by the one of the call, and its metrics are tagged with its key.
+func (r *gcpRequest) bind(p *Gcp, policy GcpRetryPolicy) (err error) {
+	r.via = p
+	r.key = p.keyName
+	r.retry, err = p.retryPolicy(policy)
+
+	return err
+}
+
+// The function returns the context of the call, holding the span of the call, through which the
+// transports of the clients count the payload bytes of the call and API calls find their retry policy.
+func (r *gcpRequest) withContext(ctx context.Context) context.Context {
+	r.bytes = &byteCounter{}
+	return context.WithValue(r.startSpan(ctx), gcpRequestKey{}, r)
+}
+
+// The function adds payload bytes counted by the caller, for clients sending them in the background. The
+// call context must have been returned by `withContext`.
+func (r *gcpRequest) addBytes(sent int, received int) {
+	r.bytes.sent.Add(int64(sent))
+	r.bytes.received.Add(int64(received))
+}
+
+// The function emits the metrics and ends the span of the call once it ended with the given error, to be
+// deferred with a named error result.
+func (r *gcpRequest) end(err *error) {
+	r.endSpan(*err)
+
+	failed := 0.0
+	if *err != nil {
+		failed = 1
+	}
+
+	tags := map[string]string{
+		"service":     r.service,
+		"method":      r.method,
+		"status_code": errorCode(*err),
+	}
+	if r.resource != "" {
+		tags["resource"] = r.resource
+	}
+	if r.key != "" {
+		tags["key"] = r.key
+	}
+
+	m := r.g.metrics
+	r.g.pushMetric(m.Requests, 1, tags)
+	r.g.pushMetric(m.RequestDuration, metrics.D(time.Since(r.start)), tags)
+	r.g.pushMetric(m.RequestFailed, failed, tags)
+	if r.bytes != nil {
+		r.g.pushMetric(m.BytesSent, float64(r.bytes.sent.Load()), tags)
+		r.g.pushMetric(m.BytesReceived, float64(r.bytes.received.Load()), tags)
+	}
+}
+
+// The function returns the instance the call is made with, the one it was bound to or else the one
+// starting it.
+func (r *gcpRequest) caller() *Gcp {
+	if r.via != nil {
+		return r.via
+	}
+
+	return r.g
+}
+
+func requestFromContext(ctx context.Context) *gcpRequest {
+	r, _ := ctx.Value(gcpRequestKey{}).(*gcpRequest)
+	return r
+}
+
+func bytesFromContext(ctx context.Context) *byteCounter {
+	if r := requestFromContext(ctx); r != nil {
+		return r.bytes
+	}
+
+	return nil
+}
+
+func (t countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	b := bytesFromContext(req.Context())
+	if b == nil {
+		return t.base.RoundTrip(req)
+	}
+
+	if req.ContentLength > 0 {
+		b.sent.Add(req.ContentLength)
+	}
+
+	res, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	res.Body = countingBody{ReadCloser: res.Body, bytes: b}
+
+	return res, nil
+}
+
+func (c countingBody) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.bytes.received.Add(int64(n))
+
+	return n, err
+}
+
+func (countingStatsHandler) TagRPC(ctx context.Context, _ *stats.RPCTagInfo) context.Context {
+	return ctx
+}
+
+func (countingStatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	b := bytesFromContext(ctx)
+	if b == nil {
+		return
+	}
+
+	switch p := s.(type) {
+	case *stats.OutPayload:
+		b.sent.Add(int64(p.WireLength))
+	case *stats.InPayload:
+		b.received.Add(int64(p.WireLength))
+	}
+}
+
+func (countingStatsHandler) TagConn(ctx context.Context, _ *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+func (countingStatsHandler) HandleConn(context.Context, stats.ConnStats) {}
@@ -0,0 +1,76 @@
+package gcp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequestMetrics(t *testing.T) {
+	sheets := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v4/spreadsheets/sheet-id/values/Users!A:A" {
+			http.Error(w, `{"error":{"code":404,"message":"not found"}}`, http.StatusNotFound)
+			return
+		}
+
+		writeValues(t, w, [][]interface{}{{"id"}, {"1"}})
+	}))
+	t.Cleanup(sheets.Close)
+
+	tests := []struct {
+		name          string
+		spreadsheetId string
+		statusCode    string
+		failed        float64
+	}{
+		{"success", "sheet-id", "OK", 0},
+		{"failure", "missing", "NOT_FOUND", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mi, samples := newTestModuleInstance(t)
+			tokens, _ := tokenServer(t)
+			g, err := mi.gcpFromConfig(GcpConfig{
+				Key:           testServiceAccountKey(t, tokens.URL),
+				ClientOptions: GcpClientOptions{Services: map[string]GcpClientOptions{sheetsService: {Endpoint: sheets.URL + "/"}}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err := g.SpreadsheetGet(tt.spreadsheetId, "Users", "A:A", CallOptions{}); (err != nil) != (tt.failed == 1) {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			values := make(map[string]float64)
+			for _, s := range collectSamples(samples) {
+				if service, _ := s.Tags.Get("service"); service != sheetsService {
+					continue
+				}
+
+				tags := s.Tags.Map()
+				expected := map[string]string{"service": sheetsService, "method": "spreadsheetGet", "resource": tt.spreadsheetId, "status_code": tt.statusCode}
+				for k, v := range expected {
+					if tags[k] != v {
+						t.Errorf("expected %s to be tagged %s %s, got %v", s.Metric.Name, k, v, tags)
+					}
+				}
+				if _, ok := values[s.Metric.Name]; ok {
+					t.Errorf("expected a single %s sample", s.Metric.Name)
+				}
+				values[s.Metric.Name] = s.Value
+			}
+
+			if values["gcp_requests"] != 1 || values["gcp_request_failed"] != tt.failed || values["gcp_request_duration"] <= 0 {
+				t.Errorf("unexpected request metrics %v", values)
+			}
+			if values["gcp_bytes_received"] <= 0 {
+				t.Errorf("expected the bytes of the response to be counted, got %v", values)
+			}
+			if _, ok := values["gcp_bytes_sent"]; !ok {
+				t.Errorf("expected the bytes sent to be reported, got %v", values)
+			}
+		})
+	}
+}
@@ -1,16 +1,23 @@
 package gcp
 
 import (
+	"context"
+	"crypto/rsa"
 	"encoding/json"
 	"fmt"
-	"log"
+	"net/url"
 	"os"
-	"reflect"
+	"sync"
 
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
 	"cloud.google.com/go/pubsub"
 	"github.com/grafana/sobek"
 	"go.k6.io/k6/js/common"
 	"go.k6.io/k6/js/modules"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"golang.org/x/oauth2/google"
+	iamcredentials "google.golang.org/api/iamcredentials/v1"
+	identitytoolkit "google.golang.org/api/identitytoolkit/v1"
 	"google.golang.org/api/sheets/v4"
 )
 
@@ -21,32 +28,149 @@ func init() {
 type (
 	// RootModule is the global module instance that will create module
 	// instances for each VU.
-	RootModule struct{}
+	RootModule struct {
+		// tokens is shared by every VU so that tokens are minted once per process
+		tokens *tokenCache
+		// jwks caches the keys ID tokens are verified against
+		jwks *jwksCache
+		// identities holds the test users provisioned for every VU
+		identities *identityPool
+		// clients holds the clients shared by every VU
+		clients *clientPool
+	}
 
 	// ModuleInstance represents an instance of the JS module.
 	ModuleInstance struct {
 		// vu provides methods for accessing internal k6 objects for a VU
-		vu modules.VU
+		vu      modules.VU
+		root    *RootModule
+		metrics *gcpMetrics
+		// iterations traces the iterations of the VU
+		iterations *iterationTrace
 	}
 
 	Gcp struct {
-		// vu      modules.VU
+		// Authenticated counterpart of k6/http, exposed as `gcp.http`
+		Http *GcpHttp
+		// Firebase Authentication helpers, exposed as `gcp.firebase`
+		Firebase *GcpFirebase
+		// Test users of Identity Platform, exposed as `gcp.identity`
+		Identity *GcpIdentity
+
+		vu           modules.VU
+		tokens       *tokenCache
+		jwks         *jwksCache
+		identities   *identityPool
+		metrics      *gcpMetrics
 		emulatorHost string
 		keyByte      []byte
+		key          credentialsKey
 		scope        []string
 		projectId    string
-
-		// Client
-		sheet  *sheets.Service
-		pubsub *pubsub.Client
+		apiKey       string
+		credentials  *google.Credentials
+
+		// Emulator host per service, see `emulatorEnvs`, and whether the script configured emulators
+		// rather than only the environment
+		emulators           map[string]string
+		emulatorsConfigured bool
+		// Options of the API clients
+		clientOptions GcpClientOptions
+		// Retry policy of the calls, overridden per call
+		retry GcpRetryPolicy
+		// HTTP proxy of REST calls and their transport over the network stack of the VU
+		proxy     *url.URL
+		transport *vuTransport
+
+		// OTLP exporter of the spans, its tracer provider once acquired from the pool and the function
+		// releasing it, guarded by tracingMu
+		tracing        GcpTracingOptions
+		tracingMu      sync.Mutex
+		tracerProvider *sdktrace.TracerProvider
+		tracerRelease  func() error
+		// Span of the current iteration, shared by every instance of the VU
+		iterations *iterationTrace
+
+		// Context the VU was initialized with, which token sources and clients are bound to
+		lifetime context.Context
+
+		// Service account impersonated through IAM Credentials and its delegate chain
+		impersonateServiceAccount string
+		delegates                 []string
+
+		// IAM Credentials client, guarded by iamCredentialsMu since token sources create it while clients
+		// are being created
+		iamCredentials   *iamcredentials.Service
+		iamCredentialsMu sync.Mutex
+
+		// Private key of a service account key, parsed on first use for local signing
+		privateKey     *rsa.PrivateKey
+		privateKeyOnce sync.Once
+		privateKeyErr  error
+
+		// Token sources per scope set and audience
+		tokenSources *tokenSourceRegistry
+
+		// Instances of the named credential profiles
+		profiles map[string]*Gcp
+		// Instances of the keys calls rotate through
+		keys *keyPool
+		// Name of the key of a key pool member, the `key` tag of the metrics of its calls
+		keyName string
+
+		// Client, guarded by clientsMu and closed once the lifetime context ends
+		clientsMu     sync.Mutex
+		sheet         *sheets.Service
+		pubsub        *pubsub.Client
+		monitoring    *monitoring.QueryClient
+		firebase      *identitytoolkit.Service
+		identityAdmin *identitytoolkit.Service
+		// Topics and subscriptions per fully qualified name, bound to the PubSub client
+		topics        map[string]*pubsub.Topic
+		subscriptions map[string]*pubsub.Subscription
+		// Close or release the clients of the instance
+		closers []func() error
+
+		// Whether clients are created per instance or shared by the process, and the pool of shared clients
+		clientSharing string
+		clients       *clientPool
+		// Whether the instance owns shared clients on behalf of the pool, authorizing and sending their calls
+		// with the instance making each call
+		shared bool
 	}
 
 	GcpConfig struct {
-		// All gcloud emulator has to set XXX_EMULATOR_HOST environment variable
+		// Emulator host shared by every service, e.g. the Firebase emulator suite
 		EmulatorHost string
-		Key          ServiceAccountKey
+		// Emulator host per service: `pubsub`, `firestore`, `datastore`, `bigtable`, `spanner`, `storage`
+		// or `auth`. Services left out use `EmulatorHost`, then their XXX_EMULATOR_HOST environment
+		// variable, then the real service.
+		Emulators map[string]string
+		// Credentials JSON of type `service_account`, `external_account` or `authorized_user`, or an array
+		// of service account keys that calls rotate through
+		Key interface{}
+		// Strategy picking a key of the array for each call: `round_robin` (default), `sticky` or `random`
+		KeySelection string
 		Scope        []string
 		ProjectId    string
+		// Service account to impersonate, optionally through a chain of delegates
+		ImpersonateServiceAccount string
+		Delegates                 []string
+		// API key of the Firebase project, used to sign in Firebase users
+		ApiKey string
+		// Options of the API clients, globally and per service
+		ClientOptions GcpClientOptions
+		// Retry policy of the Sheets calls, Monitoring queries and PubSub publishes
+		Retry GcpRetryPolicy
+		// `vu` (default) for clients per VU, `process` for clients shared by every VU
+		ClientSharing string
+		// HTTP proxy of REST calls, e.g. `http://proxy:3128`, defaults to HTTP_PROXY and HTTPS_PROXY
+		Proxy string
+		// OTLP exporter of the spans of the operations
+		Tracing GcpTracingOptions
+		// Named credential profiles, selected per call with the `profile` option. Fields left empty in a
+		// profile are inherited from the top-level configuration.
+		Profiles map[string]GcpConfig
 	}
 
 	Option func(*Gcp) error
@@ -74,12 +198,25 @@ var (
 )
 
 func New() *RootModule {
-	return &RootModule{}
+	return &RootModule{
+		tokens:     newTokenCache(),
+		jwks:       newJwksCache(),
+		identities: newIdentityPool(),
+		clients:    newClientPool(),
+	}
 }
 
-func (*RootModule) NewModuleInstance(vu modules.VU) modules.Instance {
+func (r *RootModule) NewModuleInstance(vu modules.VU) modules.Instance {
+	m, err := registerMetrics(vu.InitEnv().Registry)
+	if err != nil {
+		common.Throw(vu.Runtime(), err)
+	}
+
 	return &ModuleInstance{
-		vu: vu,
+		vu:         vu,
+		root:       r,
+		metrics:    m,
+		iterations: &iterationTrace{},
 	}
 }
 
@@ -93,7 +230,6 @@ func (mi *ModuleInstance) Exports() modules.Exports {
 
 func (mi *ModuleInstance) newGcp(c sobek.ConstructorCall) *sobek.Object {
 	rt := mi.vu.Runtime()
-	const envKey = "GOOGLE_SERVICE_ACCOUNT_KEY"
 	var options GcpConfig
 
 	err := rt.ExportTo(c.Argument(0), &options)
@@ -102,12 +238,7 @@ func (mi *ModuleInstance) newGcp(c sobek.ConstructorCall) *sobek.Object {
 			fmt.Errorf("gcp constructor fails to read options: %w", err))
 	}
 
-	g, err := newGcpConstructor(
-		withGcpEmulatorHost(options.EmulatorHost),
-		withGcpConstructorKey(options.Key, envKey),
-		withGcpConstructorScope(options.Scope),
-		withGcpConstructorProjectId(options.ProjectId),
-	)
+	g, err := mi.gcpFromConfig(options)
 	if err != nil {
 		common.Throw(rt, fmt.Errorf("cannot initialize gcp constructor <%w>", err))
 	}
@@ -115,6 +246,41 @@ func (mi *ModuleInstance) newGcp(c sobek.ConstructorCall) *sobek.Object {
 	return rt.ToValue(g).ToObject(rt)
 }
 
+// This is a method of the `ModuleInstance` struct that creates a `Gcp` instance from its configuration,
+// along with the instances of its profiles.
+func (mi *ModuleInstance) gcpFromConfig(options GcpConfig) (*Gcp, error) {
+	const envKey = "GOOGLE_SERVICE_ACCOUNT_KEY"
+
+	keys, err := credentialKeys(options.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Calls made without the key pool, e.g. Firebase, use the first key
+	var key map[string]interface{}
+	if len(keys) != 0 {
+		key = keys[0]
+	}
+
+	return newGcpConstructor(
+		withGcpConstructorModule(mi),
+		withGcpConstructorEmulators(options.EmulatorHost, options.Emulators),
+		// Credentials are resolved for the configured scopes
+		withGcpConstructorScope(options.Scope),
+		withGcpConstructorKey(key, envKey),
+		withGcpConstructorProjectId(options.ProjectId),
+		withGcpConstructorImpersonation(options.ImpersonateServiceAccount, options.Delegates),
+		withGcpConstructorApiKey(options.ApiKey),
+		withGcpConstructorClientOptions(options.ClientOptions),
+		withGcpConstructorRetry(options.Retry),
+		withGcpConstructorClientSharing(mi, options.ClientSharing),
+		withGcpConstructorProxy(options.Proxy),
+		withGcpConstructorTracing(options.Tracing),
+		withGcpConstructorKeyPool(mi, options, keys),
+		withGcpConstructorProfiles(mi, options),
+	)
+}
+
 func convertToByte(key interface{}) ([]byte, error) {
 	b, err := json.Marshal(key)
 	if err != nil {
@@ -125,54 +291,88 @@ func convertToByte(key interface{}) ([]byte, error) {
 }
 
 // The function creates a new instance of the Gcp struct with specified options.
-func newGcpConstructor(opts ...Option) (Gcp, error) {
-	g := Gcp{
-		scope: gcpConstructorDefaultScope,
+func newGcpConstructor(opts ...Option) (*Gcp, error) {
+	g := &Gcp{
+		lifetime:     context.Background(),
+		scope:        gcpConstructorDefaultScope,
+		tokenSources: newTokenSourceRegistry(),
+		iterations:   &iterationTrace{},
 	}
 
+	g.transport = &vuTransport{g: g}
+	g.Http = &GcpHttp{g: g}
+	g.Firebase = &GcpFirebase{g: g}
+	g.Identity = &GcpIdentity{g: g}
+
 	for _, opt := range opts {
-		if err := opt(&g); err != nil {
-			return Gcp{}, fmt.Errorf("gcp constructor fails to read options %w", err)
+		if err := opt(g); err != nil {
+			return nil, fmt.Errorf("gcp constructor fails to read options %w", err)
 		}
 	}
 
+	g.closeClientsOnDone()
+
 	return g, nil
 }
 
-func withGcpConstructorKey(key ServiceAccountKey, env string) func(*Gcp) error {
+func withGcpConstructorModule(mi *ModuleInstance) func(*Gcp) error {
 	return func(g *Gcp) error {
-		if !isStructEmpty(key) {
-			b, err := convertToByte(key)
-			if err != nil {
-				return err
-			}
-			g.keyByte = b
+		g.vu = mi.vu
+		g.lifetime = g.vuContext()
+		g.tokens = mi.root.tokens
+		g.jwks = mi.root.jwks
+		g.identities = mi.root.identities
+		g.metrics = mi.metrics
+		g.iterations = mi.iterations
 
-			return nil
-		}
+		return nil
+	}
+}
 
-		if envString := os.Getenv(env); envString != "" {
-			s := &ServiceAccountKey{}
-			err := json.Unmarshal([]byte(envString), &s)
+func withGcpConstructorKey(key map[string]interface{}, env string) func(*Gcp) error {
+	return func(g *Gcp) error {
+		if len(key) != 0 {
+			b, err := convertToByte(key)
 			if err != nil {
-				return fmt.Errorf("cannot unmarshal environment variable %v <%w>", env, err)
+				return err
 			}
-
-			b, err := convertToByte(s)
+			k, err := parseCredentialsKey(b)
 			if err != nil {
 				return err
 			}
+			g.key = k
 			g.keyByte = b
-
-			return nil
+		} else if envString := os.Getenv(env); envString != "" {
+			k, err := parseCredentialsKey([]byte(envString))
+			if err != nil {
+				return fmt.Errorf("invalid environment variable %v <%w>", env, err)
+			}
+			g.key = k
+			g.keyByte = []byte(envString)
 		}
 
-		// Emulators doesn't need service account
-		if g.emulatorHost != "" {
+		// Without an explicit key, fall back to Application Default Credentials
+		c, err := findCredentials(g.lifetime, g.keyByte, g.scope)
+		if err != nil && g.keyByte == nil && g.emulatorsConfigured {
+			// Scripts configured to call emulators run without credentials. Emulators only announced by
+			// the environment do not waive them, since the script may call real services too.
 			return nil
 		}
+		if err != nil {
+			return fmt.Errorf("credentials not found. Please use %s, input 'key' parameter or set up Application Default Credentials <%w>", env, err)
+		}
+		g.credentials = c
+		g.keyByte = c.JSON
+
+		// The gcloud well-known file holds authorized_user credentials, other types found through
+		// Application Default Credentials are left to the oauth2 package
+		if g.key == nil && c.JSON != nil {
+			if k, err := parseCredentialsKey(c.JSON); err == nil {
+				g.key = k
+			}
+		}
 
-		return fmt.Errorf("service account key not found. Please use %s or input 'key' parameter", env)
+		return nil
 	}
 }
 
@@ -186,50 +386,51 @@ func withGcpConstructorScope(scope []string) func(*Gcp) error {
 	}
 }
 
+// The function sets the project ID, defaulting to the project of the environment and then to the project
+// of the credentials.
 func withGcpConstructorProjectId(projectId string) func(*Gcp) error {
 	return func(g *Gcp) error {
-		if projectId != "" {
+		switch {
+		case projectId != "":
 			g.projectId = projectId
-		} else {
-			s := &ServiceAccountKey{}
-			err := json.Unmarshal(g.keyByte, s)
-			if err != nil {
-				log.Fatalf("unable to unmarshal byte <%v>", err)
-			}
-			g.projectId = s.ProjectID
+		case os.Getenv("GOOGLE_CLOUD_PROJECT") != "":
+			g.projectId = os.Getenv("GOOGLE_CLOUD_PROJECT")
+		case os.Getenv("CLOUDSDK_CORE_PROJECT") != "":
+			g.projectId = os.Getenv("CLOUDSDK_CORE_PROJECT")
+		case g.credentials != nil:
+			g.projectId = g.credentials.ProjectID
+		}
+
+		// User credentials carry no project but may bill a quota project
+		if k, ok := g.key.(*AuthorizedUserKey); ok && g.projectId == "" {
+			g.projectId = k.QuotaProjectID
 		}
 
 		return nil
 	}
 }
 
-func withGcpEmulatorHost(host string) func(*Gcp) error {
+func withGcpConstructorImpersonation(serviceAccount string, delegates []string) func(*Gcp) error {
 	return func(g *Gcp) error {
-		if host != "" {
-			g.emulatorHost = host
+		if serviceAccount == "" {
+			if len(delegates) != 0 {
+				return fmt.Errorf("delegates %s require a service account to impersonate", delegates)
+			}
+
+			return nil
 		}
 
+		g.impersonateServiceAccount = serviceAccount
+		g.delegates = delegates
+
 		return nil
 	}
 }
 
-func isStructEmpty(object interface{}) bool {
-	// check normal definitions of empty
-	if object == nil {
-		return true
-	} else if object == "" {
-		return true
-	} else if object == false {
-		return true
-	}
-
-	// see if it's a struct
-	if reflect.ValueOf(object).Kind() == reflect.Struct {
-		// and create an empty copy of the struct object to compare against
-		empty := reflect.New(reflect.TypeOf(object)).Elem().Interface()
-		if reflect.DeepEqual(object, empty) {
-			return true
-		}
+func withGcpConstructorApiKey(apiKey string) func(*Gcp) error {
+	return func(g *Gcp) error {
+		g.apiKey = apiKey
+
+		return nil
 	}
-	return false
 }
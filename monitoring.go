@@ -6,23 +6,39 @@ import (
 
 	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
 	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
-	"golang.org/x/oauth2"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc"
 )
 
+// Service name of the errors of the Monitoring methods
+const monitoringService = "monitoring"
+
 // This function is querying time series data from Google Cloud Monitoring API. It takes in a project
 // ID and a query string as parameters, and returns a slice of `monitoringpb.TimeSeriesData` and an
-// error.
-func (g *Gcp) QueryTimeSeries(projectId string, query string) ([]*monitoringpb.TimeSeriesData, error) {
-	ctx := context.Background()
+// error. The query is made with the credentials of the selected profile.
+func (g *Gcp) QueryTimeSeries(projectId string, query string, opts CallOptions) (_ []*monitoringpb.TimeSeriesData, err error) {
+	defer wrapGcpError(&err, monitoringService, "queryTimeSeries")
+
+	r := g.startRequest(monitoringService, "queryTimeSeries", projectId)
+	defer r.end(&err)
 
-	jwt, err := getJwtConfig(g.keyByte, g.scope)
+	ctx, cancel, err := g.callContext(opts.Timeout)
 	if err != nil {
 		return nil, err
 	}
+	defer cancel()
+	ctx = r.withContext(ctx)
 
-	c, err := queryClient(ctx, jwt.TokenSource(ctx))
+	p, err := g.resolve(opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.bind(p, opts.Retry); err != nil {
+		return nil, err
+	}
+
+	c, err := p.monitoringClient()
 	if err != nil {
 		return nil, err
 	}
@@ -32,32 +48,59 @@ func (g *Gcp) QueryTimeSeries(projectId string, query string) ([]*monitoringpb.T
 		Query: query,
 	}
 
-	iter := c.QueryTimeSeries(ctx, req)
+	// A failed page restarts the query, so that retries never return partial results
+	return retry(ctx, func() ([]*monitoringpb.TimeSeriesData, error) {
+		iter := c.QueryTimeSeries(ctx, req)
 
-	var result []*monitoringpb.TimeSeriesData
+		var result []*monitoringpb.TimeSeriesData
 
-	for {
-		resp, err := iter.Next()
-		if err == iterator.Done {
-			break
+		for {
+			resp, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("could not list time series: %w", err)
+			}
+			result = append(result, resp)
 		}
+
+		return result, nil
+	})
+}
+
+// This function initializes the query client of Google Cloud Monitoring. The stats handler counts the
+// bytes of each call.
+func (g *Gcp) monitoringClient() (*monitoring.QueryClient, error) {
+	g.clientsMu.Lock()
+	defer g.clientsMu.Unlock()
+
+	if g.monitoring == nil {
+		c, err := g.acquireClient(monitoringService, (*Gcp).newMonitoringClient)
 		if err != nil {
-			return nil, fmt.Errorf("could not list time series: %w", err)
+			return nil, err
 		}
-		result = append(result, resp)
-	}
 
-	defer c.Close()
+		g.monitoring = c.(*monitoring.QueryClient)
+	}
 
-	return result, nil
+	return g.monitoring, nil
 }
 
-// The function initializes a query client for Google Cloud Monitoring using a token source.
-func queryClient(ctx context.Context, ts oauth2.TokenSource) (*monitoring.QueryClient, error) {
-	c, err := monitoring.NewQueryClient(ctx, option.WithTokenSource(ts))
+// This is a method of the `Gcp` struct that creates its Monitoring query client, bound to a context.
+func (g *Gcp) newMonitoringClient(ctx context.Context) (interface{}, func() error, error) {
+	auth, err := g.authOptions(monitoringService, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	options := append(g.serviceClientOptions(monitoringService).grpcOptions(), auth...)
+	options = append(options, option.WithGRPCDialOption(grpc.WithStatsHandler(countingStatsHandler{})))
+
+	c, err := monitoring.NewQueryClient(ctx, options...)
 	if err != nil {
-		return nil, fmt.Errorf("could not initialize query client <%w>", err)
+		return nil, nil, fmt.Errorf("could not initialize query client <%w>", err)
 	}
 
-	return c, nil
+	return c, c.Close, nil
 }
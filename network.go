@@ -0,0 +1,201 @@
+package gcp
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"go.k6.io/k6/lib"
+	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+)
+
+// Idle connections of REST calls are closed after this long, like the ones of the default transport
+const networkIdleConnTimeout = 90 * time.Second
+
+// Sequence of the requests logged with `--http-debug`
+var httpDebugRequestId atomic.Uint64
+
+type (
+	// HTTP transport of the REST calls of an instance, resolving the network stack of the VU on every
+	// request, so that clients created in the init context use the one of the VU once it runs. The
+	// transport is rebuilt when the VU state changes, along with its connections.
+	vuTransport struct {
+		g *Gcp
+
+		mu    sync.Mutex
+		state *lib.State
+		base  http.RoundTripper
+	}
+
+	// HTTP transport logging requests and responses like k6 does with `--http-debug`. The `Authorization`
+	// header is redacted, since it holds the tokens of the module.
+	httpDebugTransport struct {
+		base   http.RoundTripper
+		full   bool
+		logger logrus.FieldLogger
+	}
+)
+
+// This is a method of the `Gcp` struct that returns the HTTP transport of its REST calls, built from the
+// network stack of the VU: its dialer, which applies the `hosts` and `blacklistIPs` options and accounts
+// `data_sent` and `data_received`, its TLS configuration and `--http-debug`. Requests go through the
+// configured proxy, or the proxy of the environment. Outside of a VU, e.g. in the init context, the
+// default network stack is used, and calls of shared clients use the network stack of the calling VU.
+func (g *Gcp) networkTransport() http.RoundTripper {
+	return g.transport
+}
+
+func (t *vuTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Shared clients belong to no VU, their calls go through the network stack of the calling VU
+	if t.g.shared {
+		if r := requestFromContext(req.Context()); r != nil {
+			return r.caller().transport.RoundTrip(req)
+		}
+	}
+
+	return t.current().RoundTrip(req)
+}
+
+func (t *vuTransport) CloseIdleConnections() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	closeIdleConnections(t.base)
+}
+
+// The function returns the transport of the current VU state, rebuilding it when the state changed, e.g.
+// once the VU runs after the init context.
+func (t *vuTransport) current() http.RoundTripper {
+	var state *lib.State
+	if t.g.vu != nil {
+		state = t.g.vu.State()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.base == nil || state != t.state {
+		closeIdleConnections(t.base)
+		t.base = newNetworkTransport(state, t.g.proxy)
+		t.state = state
+	}
+
+	return t.base
+}
+
+// The function builds the HTTP transport of a VU state, or the default one without state.
+func newNetworkTransport(state *lib.State, proxyUrl *url.URL) http.RoundTripper {
+	proxy := http.ProxyFromEnvironment
+	if proxyUrl != nil {
+		proxy = http.ProxyURL(proxyUrl)
+	}
+
+	if state == nil {
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.Proxy = proxy
+
+		return t
+	}
+
+	t := &http.Transport{
+		Proxy:             proxy,
+		DialContext:       state.Dialer.DialContext,
+		TLSClientConfig:   state.TLSConfig.Clone(),
+		ForceAttemptHTTP2: true,
+		DisableKeepAlives: state.Options.NoConnectionReuse.Bool,
+		IdleConnTimeout:   networkIdleConnTimeout,
+	}
+
+	if state.Options.HTTPDebug.String == "" {
+		return t
+	}
+
+	return httpDebugTransport{
+		base:   t,
+		full:   state.Options.HTTPDebug.String == "full",
+		logger: state.Logger,
+	}
+}
+
+// The function closes the idle connections of a transport, if it keeps any.
+func closeIdleConnections(t http.RoundTripper) {
+	if c, ok := t.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// This is a method of the `Gcp` struct that returns the HTTP client of a REST API client, authenticated
+// with the client options over the network stack of the VU. The transport counts the bytes of each call.
+func (g *Gcp) restHttpClient(ctx context.Context, options ...option.ClientOption) (*http.Client, error) {
+	t, err := htransport.NewTransport(ctx, countingTransport{base: g.networkTransport()}, options...)
+	if err != nil {
+		return nil, fmt.Errorf("could not initialize HTTP transport <%w>", err)
+	}
+
+	return &http.Client{Transport: t}, nil
+}
+
+func (t httpDebugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := strconv.FormatUint(httpDebugRequestId.Add(1), 10)
+
+	r := req.Clone(req.Context())
+	if r.Header.Get("Authorization") != "" {
+		r.Header.Set("Authorization", "[redacted]")
+	}
+	// Dumping the body consumes it, so the dump reads a copy and the original request keeps its own. Without
+	// a copy the body is left out, which leaves the body of the request untouched.
+	full := t.full && req.GetBody != nil
+	if full {
+		body, err := req.GetBody()
+		if err == nil {
+			r.Body = body
+		}
+		full = err == nil
+	}
+
+	dump, err := httputil.DumpRequestOut(r, full)
+	if err != nil {
+		t.logger.Error(err)
+	}
+	t.logger.WithField("request_id", id).Infof("Request:\n%s\n", bytes.ReplaceAll(dump, []byte("\r\n"), []byte{'\n'}))
+
+	res, err := t.base.RoundTrip(req)
+	if res != nil {
+		dump, err := httputil.DumpResponse(res, t.full)
+		if err != nil {
+			t.logger.Error(err)
+		}
+		t.logger.WithField("request_id", id).Infof("Response:\n%s\n", bytes.ReplaceAll(dump, []byte("\r\n"), []byte{'\n'}))
+	}
+
+	return res, err
+}
+
+func (t httpDebugTransport) CloseIdleConnections() {
+	closeIdleConnections(t.base)
+}
+
+func withGcpConstructorProxy(proxy string) func(*Gcp) error {
+	return func(g *Gcp) error {
+		if proxy == "" {
+			return nil
+		}
+
+		u, err := url.Parse(proxy)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid proxy %s, expected a URL such as http://proxy:3128", proxy)
+		}
+		g.proxy = u
+
+		return nil
+	}
+}
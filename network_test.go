@@ -0,0 +1,75 @@
+package gcp
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/guregu/null.v3"
+)
+
+func TestHttpDebugRedactsAuthorization(t *testing.T) {
+	tests := []struct {
+		name   string
+		debug  string
+		logged bool
+	}{
+		{"headers", "headers", false},
+		{"full", "full", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var authorization, body string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authorization = r.Header.Get("Authorization")
+				b, _ := io.ReadAll(r.Body)
+				body = string(b)
+				_, _ = w.Write([]byte("response-body"))
+			}))
+			t.Cleanup(server.Close)
+
+			var logs bytes.Buffer
+			logger := logrus.New()
+			logger.SetOutput(&logs)
+
+			mi, _ := newTestModuleInstance(t)
+			state := mi.vu.State()
+			state.Logger = logger
+			state.Options.HTTPDebug = null.StringFrom(tt.debug)
+
+			client := &http.Client{Transport: newNetworkTransport(state, nil)}
+			req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("request-body"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Authorization", "Bearer secret-token")
+
+			res, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if authorization != "Bearer secret-token" || body != "request-body" || string(b) != "response-body" {
+				t.Errorf("expected the request to be sent as is, got authorization %s, body %s and response %s", authorization, body, b)
+			}
+
+			out := logs.String()
+			if strings.Contains(out, "secret-token") || !strings.Contains(out, "Authorization: [redacted]") {
+				t.Errorf("expected the Authorization header to be redacted, got %s", out)
+			}
+			if strings.Contains(out, "request-body") != tt.logged || strings.Contains(out, "response-body") != tt.logged {
+				t.Errorf("expected bodies to be logged: %t, got %s", tt.logged, out)
+			}
+		})
+	}
+}
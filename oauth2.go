@@ -5,74 +5,180 @@ import (
 	"fmt"
 
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
-	"golang.org/x/oauth2/jwt"
+	"google.golang.org/api/idtoken"
+	"google.golang.org/api/option"
 )
 
+// Options of `GetOAuth2IdToken`
+type IdTokenOptions struct {
+	// Adds the `email` claim to ID tokens minted through impersonation, other credentials reject it
+	IncludeEmail bool
+	// Credential profile the token is minted for
+	Profile string
+	// Maximum time to wait for the token, e.g. `10s`
+	Timeout string
+}
+
 // This function is a method of the `Gcp` struct and is used to obtain an OAuth2 access token for a
 // given set of scopes. It takes in a variable number of scope strings as arguments and returns an
-// `oauth2.Token` and an error.
-func (g *Gcp) GetOAuth2AccessToken(scope []string) (*oauth2.Token, error) {
-	ctx := context.Background()
+// `oauth2.Token` and an error. Without scopes, the scopes of the `Gcp` struct are used. Each distinct
+// set of scopes gets its own token source, so narrowly scoped tokens for several APIs can be obtained
+// from one instance.
+func (g *Gcp) GetOAuth2AccessToken(scope []string, opts CallOptions) (*oauth2.Token, error) {
+	ctx, cancel, err := g.callContext(opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
 
-	if scope == nil {
-		scope = g.scope
+	p, err := g.resolve(opts.Profile)
+	if err != nil {
+		return nil, err
 	}
 
-	jwt, err := getJwtConfig(g.keyByte, g.scope)
+	if len(scope) == 0 {
+		scope = p.scope
+	}
+
+	ts, err := p.tokenSource(scope)
 	if err != nil {
 		return nil, err
 	}
 
-	token, err := jwt.TokenSource(ctx).Token()
+	token, err := tokenWithContext(ctx, ts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to obtain Access Token from JWT config with scope %s <%w>", scope, err)
+		return nil, fmt.Errorf("failed to obtain Access Token with scope %s <%w>", scope, err)
 	}
 
 	return token, nil
 }
 
-// This is a method of the `Gcp` struct that is used to obtain an OAuth2 ID token for a given set of
-// scopes. It takes in a variable number of scope strings as arguments and returns an `oauth2.Token`
-// and an error. It first checks if the `scope` argument is nil, and if so, it sets it to the default
-// `scope` value of the `Gcp` struct. It then calls the `getTokenSource` function to obtain a token
-// source with the specified scopes and uses it to obtain the ID token by calling the `Token` method on
-// the token source. If there is an error obtaining the token source or the token itself, an error is
-// returned.
-func (g *Gcp) GetOAuth2IdToken(scope []string) (*oauth2.Token, error) {
-	if scope == nil {
-		scope = g.scope
+// This is a method of the `Gcp` struct that is used to obtain a Google-signed OIDC ID token for a
+// given audience, e.g. the URL of a Cloud Run service, a Cloud Function or the client ID of an IAP
+// protected backend. Tokens are cached per audience so that they are reused until they near expiry.
+func (g *Gcp) GetOAuth2IdToken(audience string, options IdTokenOptions) (*oauth2.Token, error) {
+	if audience == "" {
+		return nil, fmt.Errorf("an audience is required to obtain an ID Token")
 	}
 
-	ts, err := getTokenSource(g.keyByte, g.scope)
+	ctx, cancel, err := g.callContext(options.Timeout)
 	if err != nil {
 		return nil, err
 	}
+	defer cancel()
 
-	token, err := ts.Token()
+	p, err := g.resolve(options.Profile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to obtain ID Token from JWT Token Source for scope %s <%w>", scope, err)
+		return nil, err
+	}
+
+	ts, err := p.idTokenSource(audience, options.IncludeEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := tokenWithContext(ctx, ts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain ID Token for audience %s <%w>", audience, err)
 	}
 
 	return token, nil
 }
 
-// The function returns a JWT configuration and an error, given a key byte and a scope.
-func getJwtConfig(keyByte []byte, scope []string) (*jwt.Config, error) {
-	jwt, err := google.JWTConfigFromJSON(keyByte, scope...)
+// This is a method of the `Gcp` struct that exchanges an access token through STS for a downscoped
+// token, restricted by a Credential Access Boundary to the given resources, roles and conditions, e.g.
+// the objects of a Cloud Storage bucket under a tenant prefix. Tokens are cached per boundary.
+func (g *Gcp) DownscopedToken(options DownscopedTokenOptions) (*oauth2.Token, error) {
+	ctx, cancel, err := g.callContext(options.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+
+	p, err := g.resolve(options.Profile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to obtain JWT Config for scope %s <%w>", scope, err)
+		return nil, err
 	}
 
-	return jwt, nil
+	ts, err := p.downscopedTokenSource(options)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := tokenWithContext(ctx, ts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain downscoped token <%w>", err)
+	}
+
+	return token, nil
+}
+
+// This is a method of the `Gcp` struct that returns the ID token source of an audience, backed by the
+// process-wide token cache.
+func (g *Gcp) idTokenSource(audience string, includeEmail bool) (oauth2.TokenSource, error) {
+	if g.credentials == nil {
+		return nil, fmt.Errorf("no credentials configured for audience %s", audience)
+	}
+	if err := g.checkIdTokenCredentials(includeEmail); err != nil {
+		return nil, err
+	}
+
+	key := idTokenKey(audience, includeEmail)
+
+	return g.tokenSources.get(key, func() oauth2.TokenSource {
+		return g.cachedTokenSource(key, "id_token", func() (*oauth2.Token, error) {
+			ts, err := g.newIdTokenSource(g.lifetime, audience, includeEmail)
+			if err != nil {
+				return nil, err
+			}
+
+			return ts.Token()
+		})
+	}), nil
 }
 
-// The function returns a JWT token source for a given set of credentials and scope.
-func getTokenSource(keyByte []byte, scope []string) (oauth2.TokenSource, error) {
-	ts, err := google.JWTAccessTokenSourceWithScope(keyByte, scope...)
+// This is a method of the `Gcp` struct that returns a new ID token source of an audience. Impersonated
+// credentials mint ID tokens through IAM Credentials, every other credential is exchanged for an ID
+// token by the `idtoken` package.
+func (g *Gcp) newIdTokenSource(ctx context.Context, audience string, includeEmail bool) (oauth2.TokenSource, error) {
+	var ts oauth2.TokenSource
+	var err error
+
+	if g.impersonateServiceAccount != "" {
+		ts, err = g.impersonatedIdTokenSource(ctx, audience, includeEmail)
+	} else {
+		ts, err = idtoken.NewTokenSource(ctx, audience, option.WithCredentials(g.credentials))
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to obtain JWT Token Source for scope %s <%w>", scope, err)
+		return nil, fmt.Errorf("failed to obtain ID Token Source for audience %s <%w>", audience, err)
 	}
 
 	return ts, nil
 }
+
+// This is a method of the `Gcp` struct that checks its credentials can mint ID tokens with the given
+// options. The `idtoken` package fails on user credentials with an opaque error and ignores the email
+// option, so these combinations are rejected upfront.
+func (g *Gcp) checkIdTokenCredentials(includeEmail bool) error {
+	if g.impersonateServiceAccount != "" {
+		return nil
+	}
+
+	switch k := g.key.(type) {
+	case *AuthorizedUserKey:
+		return fmt.Errorf("%s credentials cannot mint ID tokens, impersonate a service account with impersonate_service_account instead", k.Type)
+	case *ExternalAccountKey:
+		if k.ServiceAccountImpersonationURL == "" {
+			return fmt.Errorf("%s credentials only mint ID tokens with a service_account_impersonation_url", k.Type)
+		}
+
+		// ID tokens of the impersonated service account always hold its email
+		return nil
+	}
+
+	if includeEmail {
+		return fmt.Errorf("include_email requires impersonate_service_account, ID tokens of service account keys and of the metadata server are minted without the option")
+	}
+
+	return nil
+}
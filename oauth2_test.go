@@ -0,0 +1,51 @@
+package gcp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIdTokenSourceRejectsUnsupportedCredentials(t *testing.T) {
+	serviceAccount := testServiceAccountKey(t, "http://localhost/token")
+	authorizedUser := map[string]interface{}{"type": authorizedUserKey, "client_id": "id", "client_secret": "secret", "refresh_token": "token"}
+	externalAccount := func(impersonationUrl string) map[string]interface{} {
+		return map[string]interface{}{
+			"type":                              externalAccountKey,
+			"audience":                          "//iam.googleapis.com/projects/1/locations/global/workloadIdentityPools/pool/providers/provider",
+			"subject_token_type":                "urn:ietf:params:oauth:token-type:jwt",
+			"token_url":                         "http://localhost/sts",
+			"service_account_impersonation_url": impersonationUrl,
+			"credential_source":                 map[string]interface{}{"file": "/token"},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		key          map[string]interface{}
+		impersonate  string
+		includeEmail bool
+		err          string
+	}{
+		{name: "service account", key: serviceAccount},
+		{name: "service account with email", key: serviceAccount, includeEmail: true, err: "include_email requires impersonate_service_account"},
+		{name: "impersonated service account with email", key: serviceAccount, impersonate: "target@p.iam.gserviceaccount.com", includeEmail: true},
+		{name: "authorized user", key: authorizedUser, err: "authorized_user credentials cannot mint ID tokens"},
+		{name: "impersonating authorized user", key: authorizedUser, impersonate: "target@p.iam.gserviceaccount.com"},
+		{name: "external account", key: externalAccount(""), err: "only mint ID tokens with a service_account_impersonation_url"},
+		{name: "external account with impersonation", key: externalAccount("https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/target@p.iam.gserviceaccount.com:generateAccessToken"), includeEmail: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGcp(t, withGcpConstructorKey(tt.key, ""), withGcpConstructorImpersonation(tt.impersonate, nil))
+
+			_, err := g.idTokenSource("https://my-service.a.run.app", tt.includeEmail)
+			if tt.err == "" && err != nil {
+				t.Fatalf("expected ID tokens to be supported, got %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("expected error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
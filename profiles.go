@@ -0,0 +1,93 @@
+package gcp
+
+import (
+	"fmt"
+)
+
+// Trailing options of the methods of `Gcp`, selecting the credential profile the call is made with
+type CallOptions struct {
+	// Name of a profile of `GcpConfig.Profiles`, the top-level configuration is used when empty
+	Profile string
+	// Maximum duration of the call, e.g. `10s`. Calls are otherwise only bounded by the VU context.
+	Timeout string
+	// Retry policy of the call, overriding the fields of `GcpConfig.Retry`
+	Retry GcpRetryPolicy
+}
+
+func withGcpConstructorProfiles(mi *ModuleInstance, config GcpConfig) func(*Gcp) error {
+	return func(g *Gcp) error {
+		if len(config.Profiles) == 0 {
+			return nil
+		}
+
+		g.profiles = make(map[string]*Gcp, len(config.Profiles))
+		for name, profile := range config.Profiles {
+			if name == "" {
+				return fmt.Errorf("profile names cannot be empty")
+			}
+			if len(profile.Profiles) != 0 {
+				return fmt.Errorf("profile %s cannot declare nested profiles", name)
+			}
+
+			p, err := mi.gcpFromConfig(mergeGcpConfig(config, profile))
+			if err != nil {
+				return fmt.Errorf("invalid profile %s <%w>", name, err)
+			}
+			g.profiles[name] = p
+		}
+
+		return nil
+	}
+}
+
+// The function returns the configuration of a profile, where every field left empty is inherited from
+// the top-level configuration.
+func mergeGcpConfig(base GcpConfig, profile GcpConfig) GcpConfig {
+	c := base
+	c.Profiles = nil
+
+	if profile.EmulatorHost != "" {
+		c.EmulatorHost = profile.EmulatorHost
+	}
+	if len(profile.Emulators) != 0 {
+		c.Emulators = make(map[string]string, len(base.Emulators)+len(profile.Emulators))
+		for service, host := range base.Emulators {
+			c.Emulators[service] = host
+		}
+		for service, host := range profile.Emulators {
+			c.Emulators[service] = host
+		}
+	}
+	if profile.Key != nil {
+		c.Key = profile.Key
+	}
+	if profile.KeySelection != "" {
+		c.KeySelection = profile.KeySelection
+	}
+	if len(profile.Scope) != 0 {
+		c.Scope = profile.Scope
+	}
+	if profile.ProjectId != "" {
+		c.ProjectId = profile.ProjectId
+	}
+	if profile.ImpersonateServiceAccount != "" {
+		c.ImpersonateServiceAccount = profile.ImpersonateServiceAccount
+		c.Delegates = profile.Delegates
+	}
+	if profile.ApiKey != "" {
+		c.ApiKey = profile.ApiKey
+	}
+	c.ClientOptions = mergeClientOptions(base.ClientOptions, profile.ClientOptions)
+	c.Retry = mergeRetryPolicy(base.Retry, profile.Retry)
+	if profile.Tracing.Endpoint != "" {
+		c.Tracing = profile.Tracing
+	}
+	if profile.Proxy != "" {
+		c.Proxy = profile.Proxy
+	}
+	if profile.ClientSharing != "" {
+		c.ClientSharing = profile.ClientSharing
+	}
+
+	return c
+}
@@ -0,0 +1,88 @@
+package gcp
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMergeGcpConfig(t *testing.T) {
+	base := GcpConfig{
+		EmulatorHost:              "localhost:9000",
+		Emulators:                 map[string]string{"pubsub": "localhost:8085"},
+		Key:                       map[string]interface{}{"type": "service_account"},
+		Scope:                     []string{"base-scope"},
+		ProjectId:                 "base-project",
+		ImpersonateServiceAccount: "base@p.iam.gserviceaccount.com",
+		Delegates:                 []string{"delegate@p.iam.gserviceaccount.com"},
+		ApiKey:                    "base-api-key",
+		ClientOptions:             GcpClientOptions{QuotaProject: "base-quota", Services: map[string]GcpClientOptions{"sheets": {UserAgent: "base-agent"}}},
+		Retry:                     GcpRetryPolicy{MaxAttempts: 3, InitialBackoff: "100ms"},
+		ClientSharing:             clientSharingVu,
+		Proxy:                     "http://proxy:3128",
+		Tracing:                   GcpTracingOptions{Endpoint: "localhost:4318"},
+		Profiles:                  map[string]GcpConfig{"other": {ProjectId: "other-project"}},
+	}
+
+	tests := []struct {
+		name     string
+		profile  GcpConfig
+		expected func() GcpConfig
+	}{
+		{
+			name:    "empty profile",
+			profile: GcpConfig{},
+			expected: func() GcpConfig {
+				c := base
+				c.Profiles = nil
+				return c
+			},
+		},
+		{
+			name: "overrides",
+			profile: GcpConfig{
+				Emulators:                 map[string]string{"auth": "localhost:9099"},
+				KeySelection:              keySelectionSticky,
+				ProjectId:                 "profile-project",
+				ImpersonateServiceAccount: "profile@p.iam.gserviceaccount.com",
+				ClientOptions:             GcpClientOptions{Services: map[string]GcpClientOptions{"sheets": {Endpoint: "sheets:443"}}},
+				Retry:                     GcpRetryPolicy{MaxAttempts: 5},
+				Tracing:                   GcpTracingOptions{Endpoint: "collector:4317", Protocol: tracingProtocolGrpc},
+			},
+			expected: func() GcpConfig {
+				c := base
+				c.Profiles = nil
+				c.Emulators = map[string]string{"pubsub": "localhost:8085", "auth": "localhost:9099"}
+				c.KeySelection = keySelectionSticky
+				c.ProjectId = "profile-project"
+				// Delegates belong to the impersonated service account, the profile does not inherit them
+				c.ImpersonateServiceAccount = "profile@p.iam.gserviceaccount.com"
+				c.Delegates = nil
+				c.ClientOptions = GcpClientOptions{QuotaProject: "base-quota", Services: map[string]GcpClientOptions{"sheets": {UserAgent: "base-agent", Endpoint: "sheets:443"}}}
+				c.Retry = GcpRetryPolicy{MaxAttempts: 5, InitialBackoff: "100ms"}
+				c.Tracing = GcpTracingOptions{Endpoint: "collector:4317", Protocol: tracingProtocolGrpc}
+				return c
+			},
+		},
+		{
+			name:    "tracing without endpoint",
+			profile: GcpConfig{Tracing: GcpTracingOptions{Protocol: tracingProtocolGrpc}},
+			expected: func() GcpConfig {
+				c := base
+				c.Profiles = nil
+				return c
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if c := mergeGcpConfig(base, tt.profile); !reflect.DeepEqual(c, tt.expected()) {
+				t.Errorf("expected %+v, got %+v", tt.expected(), c)
+			}
+		})
+	}
+
+	if base.Emulators["auth"] != "" {
+		t.Error("expected the emulators of the base configuration to be left untouched")
+	}
+}
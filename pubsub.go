@@ -4,91 +4,332 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
-	"os"
+	"strings"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/pubsub"
-	"golang.org/x/oauth2/jwt"
-	"google.golang.org/api/option"
 )
 
+// Service name of the errors of the PubSub methods
+const pubsubService = "pubsub"
+
 // This function initializes Google PubSub client.
-func (g *Gcp) pubsubClient() {
+func (g *Gcp) pubsubClient() (*pubsub.Client, error) {
+	g.clientsMu.Lock()
+	defer g.clientsMu.Unlock()
+
 	if g.pubsub == nil {
-		ctx := context.Background()
-
-		var err error
-		var client *pubsub.Client
-		var options []option.ClientOption
-		var jwt *jwt.Config
-
-		if g.emulatorHost != "" {
-			os.Setenv("PUBSUB_EMULATOR_HOST", g.emulatorHost)
-			// Emulators has no capability to authenticate
-			options = append(options, option.WithoutAuthentication())
-		} else {
-			jwt, err = getJwtConfig(g.keyByte, g.scope)
-			if err != nil {
-				log.Fatalf("could not get JWT config with scope %s <%v>.", g.scope, err)
-			}
-			options = append(options, option.WithTokenSource(jwt.TokenSource(ctx)))
+		c, err := g.acquireClient(pubsubService, (*Gcp).newPubsubClient)
+		if err != nil {
+			return nil, err
 		}
 
-		client, err = pubsub.NewClient(ctx, g.projectId, options...)
+		g.pubsub = c.(*pubsub.Client)
+	}
+
+	return g.pubsub, nil
+}
+
+// This is a method of the `Gcp` struct that creates its PubSub client, bound to a context. The client of
+// an emulator is given its connection, so that PUBSUB_EMULATOR_HOST never takes precedence over the
+// emulator of the instance.
+func (g *Gcp) newPubsubClient(ctx context.Context) (interface{}, func() error, error) {
+	// Keeps the Pub/Sub library from dialing the emulator of the environment
+	emulatorEnv(emulatorPubsub)
+
+	options := g.serviceClientOptions(pubsubService).grpcOptions()
+
+	o, conn, err := g.emulatorOptions(emulatorPubsub)
+	if err != nil {
+		return nil, nil, err
+	}
+	if o != nil {
+		options = append(options, o...)
+	} else {
+		auth, err := g.authOptions(pubsubService, true)
 		if err != nil {
-			log.Fatalf("could not initialize PubSub client <%v>", err)
+			return nil, nil, err
 		}
+		options = append(options, auth...)
+	}
 
-		g.pubsub = client
+	c, err := pubsub.NewClient(ctx, g.projectId, options...)
+	if err != nil {
+		if conn != nil {
+			conn.Close()
+		}
+
+		return nil, nil, fmt.Errorf("could not initialize PubSub client <%w>", err)
 	}
+
+	return c, c.Close, nil
 }
 
-func (g *Gcp) PubsubTopic(topic string) *pubsub.Topic {
-	g.pubsubClient()
-	return g.pubsub.Topic(topic)
+// The function returns a topic bound to the Pub/Sub client of the selected credential profile. Publishes
+// to the topic are made with the profile it was bound with unless the call selects another one, and with
+// the key picked for the call when the profile has a key pool.
+func (g *Gcp) PubsubTopic(topic string, opts CallOptions) (_ *pubsub.Topic, err error) {
+	defer wrapGcpError(&err, pubsubService, "pubsubTopic")
+
+	p, err := g.resolve(opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := p.pubsubClient()
+	if err != nil {
+		return nil, err
+	}
+	return p.pubsubTopic(fmt.Sprintf("projects/%s/topics/%s", c.Project(), topic))
 }
 
-func (g *Gcp) PubsubPublish(t *pubsub.Topic, message map[string]interface{}) (string, error) {
-	ctx := context.Background()
+// The function publishes a message to a topic and waits for its ID, at most for the timeout of the call.
+func (g *Gcp) PubsubPublish(t *pubsub.Topic, message map[string]interface{}, opts CallOptions) (_ string, err error) {
+	defer wrapGcpError(&err, pubsubService, "pubsubPublish")
 
-	b, err := json.Marshal(message)
+	r := g.startRequest(pubsubService, "pubsubPublish", t.ID())
+	defer r.end(&err)
+
+	ctx, cancel, err := g.callContext(opts.Timeout)
+	if err != nil {
+		return "", err
+	}
+	defer cancel()
+	ctx = r.withContext(ctx)
+
+	p, err := g.resolve(g.pubsubProfile(opts.Profile, func(m *Gcp) bool { return m.boundTopic(t) }))
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal data to JSON <%v>", err)
+		return "", err
+	}
+	if err = r.bind(p, opts.Retry); err != nil {
+		return "", err
+	}
+	if t, err = p.pubsubTopic(t.String()); err != nil {
+		return "", err
 	}
 
-	res := t.Publish(ctx, &pubsub.Message{Data: b})
+	b, err := json.Marshal(message)
+	if err != nil {
+		return "", withCode("INVALID_ARGUMENT", fmt.Errorf("failed to marshal data to JSON <%w>", err))
+	}
 
-	msgId, err := res.Get(ctx)
+	msgId, err := retry(ctx, func() (string, error) {
+		// Messages are published in the background, so their bytes are counted here
+		r.addBytes(len(b), 0)
+		// Consumers continue the trace of the publish from the W3C trace context of the attributes
+		return t.Publish(ctx, &pubsub.Message{Data: b, Attributes: traceContextAttributes(ctx)}).Get(ctx)
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to get message ID <%v>", err)
+		return "", fmt.Errorf("failed to get message ID <%w>", err)
 	}
 
 	return msgId, nil
 }
 
-func (g *Gcp) PubsubSubscription(subscription string) *pubsub.Subscription {
-	g.pubsubClient()
-	return g.pubsub.Subscription(subscription)
+// The function returns a subscription bound to the Pub/Sub client of the selected credential profile.
+// Like topics, it is received from with the profile it was bound with unless the call selects another one.
+func (g *Gcp) PubsubSubscription(subscription string, opts CallOptions) (_ *pubsub.Subscription, err error) {
+	defer wrapGcpError(&err, pubsubService, "pubsubSubscription")
+
+	p, err := g.resolve(opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := p.pubsubClient()
+	if err != nil {
+		return nil, err
+	}
+	return p.pubsubSubscription(fmt.Sprintf("projects/%s/subscriptions/%s", c.Project(), subscription))
 }
 
-func (g *Gcp) PubsubReceive(s *pubsub.Subscription, limit int, timeout int) ([]map[string]interface{}, error) {
-	ctx := context.Background()
-	s.ReceiveSettings.MaxOutstandingMessages = limit
-	s.ReceiveSettings.MaxExtension = time.Duration(timeout) * time.Second
+// The function receives messages of a subscription until the timeout of the call, or until the VU context
+// ends, e.g. when the test is aborted.
+func (g *Gcp) PubsubReceive(s *pubsub.Subscription, limit int, timeout int, opts CallOptions) (_ []map[string]interface{}, err error) {
+	defer wrapGcpError(&err, pubsubService, "pubsubReceive")
+
+	r := g.startRequest(pubsubService, "pubsubReceive", s.ID())
+	defer r.end(&err)
+
+	ctx, cancel, err := g.callContext(opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	ctx = r.withContext(ctx)
+
+	p, err := g.resolve(g.pubsubProfile(opts.Profile, func(m *Gcp) bool { return m.boundSubscription(s) }))
+	if err != nil {
+		return nil, err
+	}
+	if err = r.bind(p, opts.Retry); err != nil {
+		return nil, err
+	}
+	c, err := p.pubsubClient()
+	if err != nil {
+		return nil, err
+	}
+	if s, err = receiveSubscription(c, s, limit, timeout); err != nil {
+		return nil, err
+	}
+
 	var list []map[string]interface{}
-	err := s.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
+	var mu sync.Mutex
+	var unmarshalErr error
+	err = s.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
+		r.addBytes(0, len(m.Data))
 		var message map[string]interface{}
-		err := json.Unmarshal(m.Data, &message)
-		if err != nil {
-			log.Fatalf("unable to unmarshal subscription data <%v>", err)
+		if err := json.Unmarshal(m.Data, &message); err != nil {
+			// Stop receiving and leave the message to be redelivered
+			m.Nack()
+			mu.Lock()
+			if unmarshalErr == nil {
+				unmarshalErr = withCode("INVALID_ARGUMENT", fmt.Errorf("unable to unmarshal subscription data of message %s <%w>", m.ID, err))
+			}
+			mu.Unlock()
+			cancel()
+			return
 		}
+		mu.Lock()
 		list = append(list, message)
+		mu.Unlock()
 		m.Ack()
 	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to receive data from subscription %s <%v>", s, err)
+		return nil, fmt.Errorf("unable to receive data from subscription %s <%w>", s, err)
+	}
+	if unmarshalErr != nil {
+		return nil, unmarshalErr
 	}
 
 	return list, nil
 }
+
+// The function returns a copy of a subscription bound to the client for one receive, since receives may
+// overlap and a subscription only runs one at a time. The copy keeps the receive settings the script set,
+// except for the limit and timeout of the receive.
+func receiveSubscription(c *pubsub.Client, s *pubsub.Subscription, limit int, timeout int) (*pubsub.Subscription, error) {
+	project, id, err := pubsubResource(s.String(), "subscriptions")
+	if err != nil {
+		return nil, err
+	}
+
+	r := c.SubscriptionInProject(id, project)
+	r.ReceiveSettings = s.ReceiveSettings
+	r.ReceiveSettings.MaxOutstandingMessages = limit
+	r.ReceiveSettings.MaxExtension = time.Duration(timeout) * time.Second
+
+	return r, nil
+}
+
+// This is a method of the `Gcp` struct that returns its topic of a fully qualified name, so that a topic
+// bound to another instance, e.g. another key of a key pool, is published to with this one.
+func (g *Gcp) pubsubTopic(name string) (*pubsub.Topic, error) {
+	c, err := g.pubsubClient()
+	if err != nil {
+		return nil, err
+	}
+
+	g.clientsMu.Lock()
+	defer g.clientsMu.Unlock()
+
+	if t, ok := g.topics[name]; ok {
+		return t, nil
+	}
+	if err := g.checkLifetime(); err != nil {
+		return nil, err
+	}
+
+	project, id, err := pubsubResource(name, "topics")
+	if err != nil {
+		return nil, err
+	}
+	if g.topics == nil {
+		g.topics = make(map[string]*pubsub.Topic)
+	}
+	g.topics[name] = c.TopicInProject(id, project)
+
+	return g.topics[name], nil
+}
+
+// This is a method of the `Gcp` struct that returns its subscription of a fully qualified name, through
+// which receives find the profile the subscription was bound with.
+func (g *Gcp) pubsubSubscription(name string) (*pubsub.Subscription, error) {
+	c, err := g.pubsubClient()
+	if err != nil {
+		return nil, err
+	}
+
+	g.clientsMu.Lock()
+	defer g.clientsMu.Unlock()
+
+	if s, ok := g.subscriptions[name]; ok {
+		return s, nil
+	}
+	if err := g.checkLifetime(); err != nil {
+		return nil, err
+	}
+
+	project, id, err := pubsubResource(name, "subscriptions")
+	if err != nil {
+		return nil, err
+	}
+	if g.subscriptions == nil {
+		g.subscriptions = make(map[string]*pubsub.Subscription)
+	}
+	g.subscriptions[name] = c.SubscriptionInProject(id, project)
+
+	return g.subscriptions[name], nil
+}
+
+// This is a method of the `Gcp` struct that returns the profile a topic or subscription is used with: the
+// profile of the call, else the profile whose instances bound it, else the top-level configuration.
+func (g *Gcp) pubsubProfile(profile string, bound func(*Gcp) bool) string {
+	if profile != "" {
+		return profile
+	}
+
+	for name, p := range g.profiles {
+		if bound(p) {
+			return name
+		}
+		if p.keys == nil {
+			continue
+		}
+		for _, m := range p.keys.members {
+			if bound(m) {
+				return name
+			}
+		}
+	}
+
+	return ""
+}
+
+// This is a method of the `Gcp` struct that returns whether the topic was bound by the instance.
+func (g *Gcp) boundTopic(t *pubsub.Topic) bool {
+	g.clientsMu.Lock()
+	defer g.clientsMu.Unlock()
+
+	return g.topics[t.String()] == t
+}
+
+// This is a method of the `Gcp` struct that returns whether the subscription was bound by the instance.
+func (g *Gcp) boundSubscription(s *pubsub.Subscription) bool {
+	g.clientsMu.Lock()
+	defer g.clientsMu.Unlock()
+
+	return g.subscriptions[s.String()] == s
+}
+
+// The function splits the fully qualified name of a topic or subscription, e.g.
+// `projects/my-project/topics/my-topic`, into its project and ID.
+func pubsubResource(name string, collection string) (string, string, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) != 4 || parts[0] != "projects" || parts[2] != collection || parts[1] == "" || parts[3] == "" {
+		return "", "", withCode("INVALID_ARGUMENT", fmt.Errorf("invalid %s name %s, expected projects/<project>/%s/<id>", collection, name, collection))
+	}
+
+	return parts[1], parts[3], nil
+}
@@ -0,0 +1,98 @@
+package gcp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub"
+	pb "cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+)
+
+func TestPubsubClientEmulatorPrecedence(t *testing.T) {
+	servers := make(map[string]*pstest.Server)
+	for _, name := range []string{"env", "host", "entry"} {
+		s := pstest.NewServer()
+		t.Cleanup(func() { s.Close() })
+
+		// Each emulator only knows the topic named after it
+		if _, err := s.GServer.CreateTopic(context.Background(), &pb.Topic{Name: "projects/p/topics/" + name}); err != nil {
+			t.Fatal(err)
+		}
+		servers[name] = s
+	}
+
+	tests := []struct {
+		name      string
+		host      string
+		emulators map[string]string
+		expected  string
+	}{
+		{"environment", "", nil, "env"},
+		{"emulator_host over the environment", servers["host"].Addr, nil, "host"},
+		{"entry over emulator_host", servers["host"].Addr, map[string]string{emulatorPubsub: servers["entry"].Addr}, "entry"},
+		{"entry of another service", servers["host"].Addr, map[string]string{emulatorAuth: "localhost:9099"}, "host"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("PUBSUB_EMULATOR_HOST", servers["env"].Addr)
+			t.Cleanup(func() { pubsubEmulatorEnv = "" })
+
+			g, err := newGcpConstructor(withGcpConstructorEmulators(tt.host, tt.emulators), withGcpConstructorProjectId("p"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			c, closeClient, err := g.newPubsubClient(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { _ = closeClient() })
+
+			for name := range servers {
+				ok, err := c.(*pubsub.Client).Topic(name).Exists(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok != (name == tt.expected) {
+					t.Errorf("expected the client to call the %s emulator, topic %s exists: %t", tt.expected, name, ok)
+				}
+			}
+		})
+	}
+}
+
+func TestReceiveSubscriptionKeepsSettings(t *testing.T) {
+	server := pstest.NewServer()
+	t.Cleanup(func() { server.Close() })
+
+	g := newTestGcp(t, withGcpConstructorEmulators("", map[string]string{emulatorPubsub: server.Addr}), withGcpConstructorProjectId("p"))
+	client, closeClient, err := g.newPubsubClient(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = closeClient() })
+	c := client.(*pubsub.Client)
+
+	s := c.Subscription("orders")
+	s.ReceiveSettings.NumGoroutines = 3
+	s.ReceiveSettings.Synchronous = true
+	s.ReceiveSettings.MaxOutstandingBytes = 1024
+
+	r, err := receiveSubscription(c, s, 10, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r == s || r.String() != s.String() {
+		t.Fatalf("expected a copy of subscription %s, got %s", s, r)
+	}
+
+	expected := s.ReceiveSettings
+	expected.MaxOutstandingMessages = 10
+	expected.MaxExtension = 5 * time.Second
+	if r.ReceiveSettings != expected {
+		t.Errorf("expected receive settings %+v, got %+v", expected, r.ReceiveSettings)
+	}
+}
@@ -0,0 +1,230 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/code"
+)
+
+const (
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 10 * time.Second
+	defaultRetryMultiplier     = 2
+)
+
+type (
+	// Retry policy of the Sheets calls, Monitoring queries and PubSub publishes, configured globally with
+	// `GcpConfig.Retry` and per call with `CallOptions.Retry`. Fields left empty in a call are inherited
+	// from the global policy.
+	GcpRetryPolicy struct {
+		// Attempts of a call including the first one, calls are not retried by default
+		MaxAttempts int
+		// Backoff before the first retry, e.g. `100ms`
+		InitialBackoff string
+		// Upper bound of the backoff, e.g. `10s`
+		MaxBackoff string
+		// Growth of the backoff after each retry
+		Multiplier float64
+		// Canonical codes worth retrying, or HTTP statuses such as 429 and 503 mapped to their canonical
+		// code, defaults to the codes of retryable `GcpError`
+		RetryOn []string
+	}
+
+	retryPolicy struct {
+		maxAttempts    int
+		initialBackoff time.Duration
+		maxBackoff     time.Duration
+		multiplier     float64
+		retryOn        map[string]bool
+	}
+)
+
+func withGcpConstructorRetry(policy GcpRetryPolicy) func(*Gcp) error {
+	return func(g *Gcp) error {
+		if _, err := policy.compile(); err != nil {
+			return fmt.Errorf("invalid retry policy <%w>", err)
+		}
+		g.retry = policy
+
+		return nil
+	}
+}
+
+// This is a method of the `Gcp` struct that returns the retry policy of a call, where the fields set for
+// the call override the global policy.
+func (g *Gcp) retryPolicy(policy GcpRetryPolicy) (retryPolicy, error) {
+	p, err := mergeRetryPolicy(g.retry, policy).compile()
+	if err != nil {
+		return retryPolicy{}, withCode("INVALID_ARGUMENT", fmt.Errorf("invalid retry policy <%w>", err))
+	}
+
+	return p, nil
+}
+
+func (p GcpRetryPolicy) compile() (retryPolicy, error) {
+	r := retryPolicy{
+		maxAttempts:    1,
+		initialBackoff: defaultRetryInitialBackoff,
+		maxBackoff:     defaultRetryMaxBackoff,
+		multiplier:     defaultRetryMultiplier,
+		retryOn:        retryableCodes,
+	}
+
+	var err error
+	if p.MaxAttempts < 0 {
+		return r, fmt.Errorf("max_attempts must be a positive number of attempts, got %d", p.MaxAttempts)
+	}
+	if p.MaxAttempts > 0 {
+		r.maxAttempts = p.MaxAttempts
+	}
+	if p.InitialBackoff != "" {
+		if r.initialBackoff, err = time.ParseDuration(p.InitialBackoff); err != nil {
+			return r, fmt.Errorf("invalid initial_backoff %s <%w>", p.InitialBackoff, err)
+		}
+	}
+	if p.MaxBackoff != "" {
+		if r.maxBackoff, err = time.ParseDuration(p.MaxBackoff); err != nil {
+			return r, fmt.Errorf("invalid max_backoff %s <%w>", p.MaxBackoff, err)
+		}
+	}
+	if r.initialBackoff <= 0 || r.maxBackoff < r.initialBackoff {
+		return r, fmt.Errorf("backoffs must be positive and initial_backoff %s cannot exceed max_backoff %s", r.initialBackoff, r.maxBackoff)
+	}
+	if p.Multiplier != 0 {
+		if p.Multiplier < 1 {
+			return r, fmt.Errorf("multiplier must be at least 1, got %g", p.Multiplier)
+		}
+		r.multiplier = p.Multiplier
+	}
+	if len(p.RetryOn) != 0 {
+		r.retryOn = make(map[string]bool, len(p.RetryOn))
+		for _, c := range p.RetryOn {
+			name, err := retryCode(c)
+			if err != nil {
+				return r, err
+			}
+			r.retryOn[name] = true
+		}
+	}
+
+	return r, nil
+}
+
+// The function returns the canonical code of a `retry_on` entry, either a canonical code or an HTTP status
+// mapped to its canonical code like the errors of REST APIs, e.g. 503 to `UNAVAILABLE`.
+func retryCode(c string) (string, error) {
+	if status, err := strconv.Atoi(c); err == nil {
+		name := httpStatusCode(status)
+		if name == code.Code_name[int32(code.Code_UNKNOWN)] {
+			return "", fmt.Errorf("retry_on HTTP status %d has no canonical code", status)
+		}
+
+		return name, nil
+	}
+
+	if _, ok := code.Code_value[c]; !ok || c == code.Code_name[int32(code.Code_OK)] {
+		return "", fmt.Errorf("unknown retry_on code %s, expected an HTTP status or one of %s", c, canonicalCodes())
+	}
+
+	return c, nil
+}
+
+// The function returns the retry policy where every field set in the override replaces the base one.
+func mergeRetryPolicy(base GcpRetryPolicy, override GcpRetryPolicy) GcpRetryPolicy {
+	p := base
+
+	if override.MaxAttempts != 0 {
+		p.MaxAttempts = override.MaxAttempts
+	}
+	if override.InitialBackoff != "" {
+		p.InitialBackoff = override.InitialBackoff
+	}
+	if override.MaxBackoff != "" {
+		p.MaxBackoff = override.MaxBackoff
+	}
+	if override.Multiplier != 0 {
+		p.Multiplier = override.Multiplier
+	}
+	if len(override.RetryOn) != 0 {
+		p.RetryOn = override.RetryOn
+	}
+
+	return p
+}
+
+// The function makes an API call, retrying it with exponential backoff and jitter while it fails with a
+// code of the retry policy of the request the context belongs to. Each retry emits the `gcp_retries`
+// metric tagged with the code as reason. Calls outside of a request are made once.
+func retry[T any](ctx context.Context, call func() (T, error)) (T, error) {
+	r := requestFromContext(ctx)
+	if r == nil {
+		return call()
+	}
+
+	backoff := r.retry.initialBackoff
+	for attempt := 1; ; attempt++ {
+		v, err := call()
+		if err == nil || attempt >= r.retry.maxAttempts || ctx.Err() != nil {
+			return v, err
+		}
+
+		reason := errorCode(err)
+		if !r.retry.retryOn[reason] {
+			return v, err
+		}
+
+		tags := map[string]string{
+			"service": r.service,
+			"method":  r.method,
+			"reason":  reason,
+		}
+		if r.resource != "" {
+			tags["resource"] = r.resource
+		}
+		if r.key != "" {
+			tags["key"] = r.key
+		}
+		r.g.pushMetric(r.g.metrics.Retries, 1, tags)
+
+		t := time.NewTimer(jitter(backoff))
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return v, err
+		}
+
+		backoff = r.retry.nextBackoff(backoff)
+	}
+}
+
+// The function returns a random delay of at most the backoff, so that retries of concurrent VUs spread out.
+func jitter(backoff time.Duration) time.Duration {
+	return time.Duration(1 + rand.Int63n(int64(backoff)))
+}
+
+// The function returns the backoff following the given one, grown by the multiplier up to the max backoff.
+func (p retryPolicy) nextBackoff(backoff time.Duration) time.Duration {
+	if backoff = time.Duration(float64(backoff) * p.multiplier); backoff > p.maxBackoff {
+		return p.maxBackoff
+	}
+
+	return backoff
+}
+
+func canonicalCodes() []string {
+	codes := make([]string, 0, len(code.Code_name))
+	for c, name := range code.Code_name {
+		if c != int32(code.Code_OK) {
+			codes = append(codes, name)
+		}
+	}
+	sort.Strings(codes)
+
+	return codes
+}
@@ -0,0 +1,174 @@
+package gcp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGcpRetryPolicyCompile(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   GcpRetryPolicy
+		expected retryPolicy
+		err      string
+	}{
+		{
+			name:     "defaults",
+			expected: retryPolicy{maxAttempts: 1, initialBackoff: defaultRetryInitialBackoff, maxBackoff: defaultRetryMaxBackoff, multiplier: defaultRetryMultiplier, retryOn: retryableCodes},
+		},
+		{
+			name:     "custom",
+			policy:   GcpRetryPolicy{MaxAttempts: 5, InitialBackoff: "50ms", MaxBackoff: "1s", Multiplier: 1.5, RetryOn: []string{"UNAVAILABLE", "NOT_FOUND"}},
+			expected: retryPolicy{maxAttempts: 5, initialBackoff: 50 * time.Millisecond, maxBackoff: time.Second, multiplier: 1.5, retryOn: map[string]bool{"UNAVAILABLE": true, "NOT_FOUND": true}},
+		},
+		{name: "negative attempts", policy: GcpRetryPolicy{MaxAttempts: -1}, err: "max_attempts must be a positive number"},
+		{name: "invalid initial backoff", policy: GcpRetryPolicy{InitialBackoff: "soon"}, err: "invalid initial_backoff soon"},
+		{name: "invalid max backoff", policy: GcpRetryPolicy{MaxBackoff: "later"}, err: "invalid max_backoff later"},
+		{name: "initial backoff over max backoff", policy: GcpRetryPolicy{InitialBackoff: "2s", MaxBackoff: "1s"}, err: "cannot exceed max_backoff"},
+		{name: "non-positive backoff", policy: GcpRetryPolicy{InitialBackoff: "0s"}, err: "backoffs must be positive"},
+		{name: "multiplier under one", policy: GcpRetryPolicy{Multiplier: 0.5}, err: "multiplier must be at least 1"},
+		{
+			name:     "HTTP statuses",
+			policy:   GcpRetryPolicy{RetryOn: []string{"429", "503", "ABORTED"}},
+			expected: retryPolicy{maxAttempts: 1, initialBackoff: defaultRetryInitialBackoff, maxBackoff: defaultRetryMaxBackoff, multiplier: defaultRetryMultiplier, retryOn: map[string]bool{"RESOURCE_EXHAUSTED": true, "UNAVAILABLE": true, "ABORTED": true}},
+		},
+		{name: "unknown code", policy: GcpRetryPolicy{RetryOn: []string{"SOMETIMES"}}, err: "unknown retry_on code SOMETIMES"},
+		{name: "HTTP status without code", policy: GcpRetryPolicy{RetryOn: []string{"418"}}, err: "retry_on HTTP status 418 has no canonical code"},
+		{name: "OK code", policy: GcpRetryPolicy{RetryOn: []string{"OK"}}, err: "unknown retry_on code OK"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := tt.policy.compile()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(p, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, p)
+			}
+		})
+	}
+}
+
+func TestMergeRetryPolicy(t *testing.T) {
+	base := GcpRetryPolicy{MaxAttempts: 3, InitialBackoff: "100ms", MaxBackoff: "5s", Multiplier: 2, RetryOn: []string{"UNAVAILABLE"}}
+
+	tests := []struct {
+		name     string
+		override GcpRetryPolicy
+		expected GcpRetryPolicy
+	}{
+		{"empty override", GcpRetryPolicy{}, base},
+		{"attempts only", GcpRetryPolicy{MaxAttempts: 1}, GcpRetryPolicy{MaxAttempts: 1, InitialBackoff: "100ms", MaxBackoff: "5s", Multiplier: 2, RetryOn: []string{"UNAVAILABLE"}}},
+		{
+			"every field",
+			GcpRetryPolicy{MaxAttempts: 5, InitialBackoff: "1s", MaxBackoff: "10s", Multiplier: 3, RetryOn: []string{"ABORTED"}},
+			GcpRetryPolicy{MaxAttempts: 5, InitialBackoff: "1s", MaxBackoff: "10s", Multiplier: 3, RetryOn: []string{"ABORTED"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if p := mergeRetryPolicy(base, tt.override); !reflect.DeepEqual(p, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, p)
+			}
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	unavailable := httpStatusError(http.StatusServiceUnavailable)
+	throttled := httpStatusError(http.StatusTooManyRequests)
+	notFound := httpStatusError(http.StatusNotFound)
+
+	tests := []struct {
+		name    string
+		policy  GcpRetryPolicy
+		errs    []error
+		calls   int
+		err     error
+		retries int
+		reason  string
+	}{
+		{name: "no retries by default", errs: []error{unavailable}, calls: 1, err: unavailable},
+		{name: "success after retries", policy: GcpRetryPolicy{MaxAttempts: 3}, errs: []error{unavailable, unavailable, nil}, calls: 3, retries: 2, reason: "UNAVAILABLE"},
+		{name: "non-retryable code", policy: GcpRetryPolicy{MaxAttempts: 3}, errs: []error{notFound}, calls: 1, err: notFound},
+		{name: "max attempts", policy: GcpRetryPolicy{MaxAttempts: 3}, errs: []error{unavailable, unavailable, unavailable, nil}, calls: 3, err: unavailable, retries: 2, reason: "UNAVAILABLE"},
+		{name: "HTTP status", policy: GcpRetryPolicy{MaxAttempts: 2, RetryOn: []string{"429"}}, errs: []error{throttled, nil}, calls: 2, retries: 1, reason: "RESOURCE_EXHAUSTED"},
+		{name: "code left out of retry_on", policy: GcpRetryPolicy{MaxAttempts: 2, RetryOn: []string{"429"}}, errs: []error{unavailable}, calls: 1, err: unavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mi, samples := newTestModuleInstance(t)
+			g, err := newGcpConstructor(withGcpConstructorModule(mi))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := g.startRequest(sheetsService, "spreadsheetGet", "sheet-id")
+			r.key = "sa@p.iam.gserviceaccount.com"
+			tt.policy.InitialBackoff = "1ms"
+			if r.retry, err = tt.policy.compile(); err != nil {
+				t.Fatal(err)
+			}
+			ctx := r.withContext(context.Background())
+
+			calls := 0
+			_, err = retry(ctx, func() (string, error) {
+				calls++
+				return "", tt.errs[calls-1]
+			})
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expected error %v, got %v", tt.err, err)
+			}
+			if calls != tt.calls {
+				t.Errorf("expected %d calls, got %d", tt.calls, calls)
+			}
+
+			retries := collectSamples(samples)
+			if len(retries) != tt.retries {
+				t.Fatalf("expected %d gcp_retries samples, got %d", tt.retries, len(retries))
+			}
+			for _, s := range retries {
+				expected := map[string]string{"service": sheetsService, "method": "spreadsheetGet", "resource": "sheet-id", "key": r.key, "reason": tt.reason}
+				for k, v := range expected {
+					if tag, _ := s.Tags.Get(k); s.Metric.Name != "gcp_retries" || tag != v {
+						t.Errorf("expected a gcp_retries sample tagged with %s %s, got %s %v", k, v, s.Metric.Name, s.Tags.Map())
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	p, err := GcpRetryPolicy{InitialBackoff: "100ms", MaxBackoff: "300ms", Multiplier: 2}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backoff := p.initialBackoff
+	for _, expected := range []time.Duration{200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond} {
+		for i := 0; i < 100; i++ {
+			if d := jitter(backoff); d <= 0 || d > backoff {
+				t.Fatalf("expected a delay within (0, %s], got %s", backoff, d)
+			}
+		}
+
+		if backoff = p.nextBackoff(backoff); backoff != expected {
+			t.Errorf("expected backoff %s, got %s", expected, backoff)
+		}
+	}
+}
@@ -3,14 +3,16 @@ package gcp
 import (
 	"context"
 	"fmt"
-	"log"
+	"net/http"
 	"strconv"
 	"strings"
 
-	"google.golang.org/api/option"
 	"google.golang.org/api/sheets/v4"
 )
 
+// Service name of the errors of the Sheets methods
+const sheetsService = "sheets"
+
 // Direct interface from https://pkg.go.dev/google.golang.org/api/sheets/v4#SpreadsheetsValuesService.
 
 // This function retrieves data from a Google Sheet.
@@ -18,19 +20,51 @@ import (
 // - spreadsheetId: the ID of the Google Sheet.
 // - sheetName: the name of the sheet to retrieve data from.
 // - cellRange: the range of cells to retrieve data from.
+// - opts: the call options, e.g. the credential profile to use.
 // Returns:
 // - [][]interface{}: a 2D slice of interface{} values representing the retrieved data.
 // - error: an error if one occurred, otherwise nil.
-func (g *Gcp) SpreadsheetGet(spreadsheetId string, sheetName string, cellRange string) ([][]interface{}, error) {
-	g.sheetClient()
+func (g *Gcp) SpreadsheetGet(spreadsheetId string, sheetName string, cellRange string, opts CallOptions) (_ [][]interface{}, err error) {
+	defer wrapGcpError(&err, sheetsService, "spreadsheetGet")
+
+	r := g.startRequest(sheetsService, "spreadsheetGet", spreadsheetId)
+	defer r.end(&err)
 
-	res, err := g.sheet.Spreadsheets.Values.Get(spreadsheetId, fmt.Sprintf("%s!%s", sheetName, cellRange)).Do()
-	if err != nil || res.HTTPStatusCode != 200 {
-		return nil, fmt.Errorf("unable to get data from range %s in sheet %s  <%v>", cellRange, sheetName, err)
+	ctx, cancel, err := g.callContext(opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	ctx = r.withContext(ctx)
+
+	p, err := g.resolve(opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.bind(p, opts.Retry); err != nil {
+		return nil, err
+	}
+
+	return p.spreadsheetGet(ctx, spreadsheetId, sheetName, cellRange)
+}
+
+func (g *Gcp) spreadsheetGet(ctx context.Context, spreadsheetId string, sheetName string, cellRange string) ([][]interface{}, error) {
+	c, err := g.sheetClient()
+	if err != nil {
+		return nil, err
+	}
+
+	call := c.Spreadsheets.Values.Get(spreadsheetId, fmt.Sprintf("%s!%s", sheetName, cellRange)).Context(ctx)
+	res, err := retry(ctx, func() (*sheets.ValueRange, error) { return call.Do() })
+	if err == nil && res.HTTPStatusCode != http.StatusOK {
+		err = httpStatusError(res.HTTPStatusCode)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to get data from range %s in sheet %s  <%w>", cellRange, sheetName, err)
 	}
 
 	if len(res.Values) == 0 {
-		return nil, fmt.Errorf("no data found in range %s on sheet %s", cellRange, sheetName)
+		return nil, withCode("NOT_FOUND", fmt.Errorf("no data found in range %s on sheet %s", cellRange, sheetName))
 	}
 
 	return res.Values, nil
@@ -41,20 +75,46 @@ func (g *Gcp) SpreadsheetGet(spreadsheetId string, sheetName string, cellRange s
 // - spreadsheetId: the ID of the Google Sheet.
 // - sheetName: the name of the sheet to append data to.
 // - valueRange: a slice of interface{} values representing the data to append.
+// - opts: the call options, e.g. the credential profile to use.
 // Returns:
 // - string: an empty string.
 // - error: an error if one occurred, otherwise nil.
-func (g *Gcp) SpreadsheetAppend(spreadsheetId string, sheetName string, valueRange []interface{}) (string, error) {
-	ctx := context.Background()
-	g.sheetClient()
+func (g *Gcp) SpreadsheetAppend(spreadsheetId string, sheetName string, valueRange []interface{}, opts CallOptions) (_ string, err error) {
+	defer wrapGcpError(&err, sheetsService, "spreadsheetAppend")
+
+	r := g.startRequest(sheetsService, "spreadsheetAppend", spreadsheetId)
+	defer r.end(&err)
+
+	ctx, cancel, err := g.callContext(opts.Timeout)
+	if err != nil {
+		return "", err
+	}
+	defer cancel()
+	ctx = r.withContext(ctx)
+
+	p, err := g.resolve(opts.Profile)
+	if err != nil {
+		return "", err
+	}
+	if err = r.bind(p, opts.Retry); err != nil {
+		return "", err
+	}
+	c, err := p.sheetClient()
+	if err != nil {
+		return "", err
+	}
 
 	row := &sheets.ValueRange{
 		Values: [][]interface{}{valueRange},
 	}
 
-	res, err := g.sheet.Spreadsheets.Values.Append(spreadsheetId, sheetName, row).ValueInputOption("RAW").InsertDataOption("INSERT_ROWS").Context(ctx).Do()
-	if err != nil || res.HTTPStatusCode != 200 {
-		return "", fmt.Errorf("unable to append data into sheet %s <%v>", sheetName, err)
+	call := c.Spreadsheets.Values.Append(spreadsheetId, sheetName, row).ValueInputOption("RAW").InsertDataOption("INSERT_ROWS").Context(ctx)
+	res, err := retry(ctx, func() (*sheets.AppendValuesResponse, error) { return call.Do() })
+	if err == nil && res.HTTPStatusCode != http.StatusOK {
+		err = httpStatusError(res.HTTPStatusCode)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to append data into sheet %s <%w>", sheetName, err)
 	}
 
 	return "", nil
@@ -66,20 +126,46 @@ func (g *Gcp) SpreadsheetAppend(spreadsheetId string, sheetName string, valueRan
 // - sheetName: the name of the sheet to update data in.
 // - cellRange: the range of cells to update data in.
 // - valueRange: a slice of interface{} values representing the data to update.
+// - opts: the call options, e.g. the credential profile to use.
 // Returns:
 // - string: an empty string.
 // - error: an error if one occurred, otherwise nil.
-func (g *Gcp) SpreadsheetUpdate(spreadsheetId string, sheetName string, cellRange string, valueRange []interface{}) (string, error) {
-	ctx := context.Background()
-	g.sheetClient()
+func (g *Gcp) SpreadsheetUpdate(spreadsheetId string, sheetName string, cellRange string, valueRange []interface{}, opts CallOptions) (_ string, err error) {
+	defer wrapGcpError(&err, sheetsService, "spreadsheetUpdate")
+
+	r := g.startRequest(sheetsService, "spreadsheetUpdate", spreadsheetId)
+	defer r.end(&err)
+
+	ctx, cancel, err := g.callContext(opts.Timeout)
+	if err != nil {
+		return "", err
+	}
+	defer cancel()
+	ctx = r.withContext(ctx)
+
+	p, err := g.resolve(opts.Profile)
+	if err != nil {
+		return "", err
+	}
+	if err = r.bind(p, opts.Retry); err != nil {
+		return "", err
+	}
+	c, err := p.sheetClient()
+	if err != nil {
+		return "", err
+	}
 
 	row := &sheets.ValueRange{
 		Values: [][]interface{}{valueRange},
 	}
 
-	res, err := g.sheet.Spreadsheets.Values.Update(spreadsheetId, fmt.Sprintf("%s!%s", sheetName, cellRange), row).ValueInputOption("RAW").Context(ctx).Do()
-	if err != nil || res.HTTPStatusCode != 200 {
-		return "", fmt.Errorf("unable to update data into sheet %s range %s <%v>", sheetName, cellRange, err)
+	call := c.Spreadsheets.Values.Update(spreadsheetId, fmt.Sprintf("%s!%s", sheetName, cellRange), row).ValueInputOption("RAW").Context(ctx)
+	res, err := retry(ctx, func() (*sheets.UpdateValuesResponse, error) { return call.Do() })
+	if err == nil && res.HTTPStatusCode != http.StatusOK {
+		err = httpStatusError(res.HTTPStatusCode)
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to update data into sheet %s range %s <%w>", sheetName, cellRange, err)
 	}
 
 	return "", nil
@@ -91,22 +177,54 @@ func (g *Gcp) SpreadsheetUpdate(spreadsheetId string, sheetName string, cellRang
 // - spreadsheetId: the ID of the Google Sheet.
 // - sheetName: the name of the sheet to search data in.
 // - filters: a map of column names to values to search for in the specified column.
+// - opts: the call options, e.g. the credential profile to use.
 // Returns:
 // - map[string]interface{}: a map of the row data if a match is found.
 // - error: an error if one occurred, otherwise nil.
-func (g *Gcp) SpreadsheetGetRowByFilters(spreadsheetId string, sheetName string, filters map[string]string) (map[string]interface{}, error) {
-	cellRange, headers, err := g.findCellRangeAndHeaders(spreadsheetId, sheetName)
+func (g *Gcp) SpreadsheetGetRowByFilters(spreadsheetId string, sheetName string, filters map[string]string, opts CallOptions) (_ map[string]interface{}, err error) {
+	defer wrapGcpError(&err, sheetsService, "spreadsheetGetRowByFilters")
+
+	r := g.startRequest(sheetsService, "spreadsheetGetRowByFilters", spreadsheetId)
+	defer r.end(&err)
+
+	ctx, cancel, err := g.callContext(opts.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer cancel()
+	ctx = r.withContext(ctx)
+
+	p, err := g.resolve(opts.Profile)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.bind(p, opts.Retry); err != nil {
+		return nil, err
+	}
+
+	return p.spreadsheetGetRowByFilters(ctx, spreadsheetId, sheetName, filters)
+}
+
+func (g *Gcp) spreadsheetGetRowByFilters(ctx context.Context, spreadsheetId string, sheetName string, filters map[string]string) (map[string]interface{}, error) {
+	cellRange, headers, err := g.findCellRangeAndHeaders(ctx, spreadsheetId, sheetName)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := g.spreadsheetGet(ctx, spreadsheetId, sheetName, cellRange)
 	if err != nil {
 		return nil, err
 	}
-	rows, _ := g.SpreadsheetGet(spreadsheetId, sheetName, cellRange)
 
 	// Find matching rows based on the filters
 	for _, row := range rows {
 		match := true
 		for key, value := range filters {
 			headerIndex := findHeaderIndex(headers, key)
-			if headerIndex == -1 || headerIndex >= len(row) || strings.TrimSpace(row[headerIndex].(string)) != value {
+			if headerIndex == -1 || headerIndex >= len(row) {
+				match = false
+				break
+			}
+			if cell, ok := row[headerIndex].(string); !ok || strings.TrimSpace(cell) != value {
 				match = false
 				break
 			}
@@ -116,7 +234,7 @@ func (g *Gcp) SpreadsheetGetRowByFilters(spreadsheetId string, sheetName string,
 		}
 	}
 
-	fmt.Printf("No row matches filters %v", filters)
+	// No row matches the filters
 	return nil, nil
 }
 
@@ -125,93 +243,191 @@ func (g *Gcp) SpreadsheetGetRowByFilters(spreadsheetId string, sheetName string,
 // - spreadsheetId: the ID of the Google Sheet.
 // - sheetName: the name of the sheet to append data to.
 // - values: a slice of interface{} values representing the data to append.
+// - opts: the call options, e.g. the credential profile to use.
 // Returns:
 // - string: the unique ID of the appended row.
 // - error: an error if one occurred, otherwise nil.
-func (g *Gcp) SpreadsheetAppendWithUniqueId(spreadsheetId string, sheetName string, values map[string]interface{}) (int64, error) {
-	ctx := context.Background()
-	g.sheetClient()
+func (g *Gcp) SpreadsheetAppendWithUniqueId(spreadsheetId string, sheetName string, values map[string]interface{}, opts CallOptions) (_ int64, err error) {
+	defer wrapGcpError(&err, sheetsService, "spreadsheetAppendWithUniqueId")
 
-	_, headers, err := g.findCellRangeAndHeaders(spreadsheetId, sheetName)
+	r := g.startRequest(sheetsService, "spreadsheetAppendWithUniqueId", spreadsheetId)
+	defer r.end(&err)
+
+	ctx, cancel, err := g.callContext(opts.Timeout)
 	if err != nil {
 		return 0, err
 	}
+	defer cancel()
+	ctx = r.withContext(ctx)
 
-	rows, _ := g.SpreadsheetGet(spreadsheetId, sheetName, "A:A")
-	id := getUniqueId(rows)
+	p, err := g.resolve(opts.Profile)
+	if err != nil {
+		return 0, err
+	}
+	if err = r.bind(p, opts.Retry); err != nil {
+		return 0, err
+	}
+	c, err := p.sheetClient()
+	if err != nil {
+		return 0, err
+	}
+
+	_, headers, err := p.findCellRangeAndHeaders(ctx, spreadsheetId, sheetName)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := p.spreadsheetGet(ctx, spreadsheetId, sheetName, "A:A")
+	if err != nil {
+		return 0, err
+	}
+	id, err := getUniqueId(rows)
+	if err != nil {
+		return 0, err
+	}
 	values["id"] = id
 
+	sorted, err := sortValuesByHeaders(headers, values)
+	if err != nil {
+		return 0, err
+	}
+
 	row := &sheets.ValueRange{
-		Values: [][]interface{}{sortValuesByHeaders(headers, values)},
+		Values: [][]interface{}{sorted},
 	}
 
-	res, err := g.sheet.Spreadsheets.Values.Append(spreadsheetId, sheetName, row).ValueInputOption("RAW").Context(ctx).Do()
-	if err != nil || res.HTTPStatusCode != 200 {
-		return 0, fmt.Errorf("unable to append data into sheet %s <%v>", sheetName, err)
+	call := c.Spreadsheets.Values.Append(spreadsheetId, sheetName, row).ValueInputOption("RAW").Context(ctx)
+	res, err := retry(ctx, func() (*sheets.AppendValuesResponse, error) { return call.Do() })
+	if err == nil && res.HTTPStatusCode != http.StatusOK {
+		err = httpStatusError(res.HTTPStatusCode)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unable to append data into sheet %s <%w>", sheetName, err)
 	}
 
 	return id, nil
 }
 
-func (g *Gcp) SpreadsheetGetUniqueIdByFiltersAndAppendIfNotExist(spreadsheetId string, sheetName string, filters map[string]string, values map[string]interface{}) (int64, error) {
+func (g *Gcp) SpreadsheetGetUniqueIdByFiltersAndAppendIfNotExist(spreadsheetId string, sheetName string, filters map[string]string, values map[string]interface{}, opts CallOptions) (_ int64, err error) {
+	defer wrapGcpError(&err, sheetsService, "spreadsheetGetUniqueIdByFiltersAndAppendIfNotExist")
+
+	r := g.startRequest(sheetsService, "spreadsheetGetUniqueIdByFiltersAndAppendIfNotExist", spreadsheetId)
+	defer r.end(&err)
+
 	var id int64
-	ctx := context.Background()
-	g.sheetClient()
+	ctx, cancel, err := g.callContext(opts.Timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer cancel()
+	ctx = r.withContext(ctx)
 
-	_, headers, err := g.findCellRangeAndHeaders(spreadsheetId, sheetName)
+	p, err := g.resolve(opts.Profile)
+	if err != nil {
+		return 0, err
+	}
+	if err = r.bind(p, opts.Retry); err != nil {
+		return 0, err
+	}
+	c, err := p.sheetClient()
+	if err != nil {
+		return 0, err
+	}
+
+	_, headers, err := p.findCellRangeAndHeaders(ctx, spreadsheetId, sheetName)
 	if err != nil {
 		return 0, err
 	}
 
-	rowByFilters, _ := g.SpreadsheetGetRowByFilters(spreadsheetId, sheetName, filters)
-	rows, _ := g.SpreadsheetGet(spreadsheetId, sheetName, "A:A")
+	rowByFilters, err := p.spreadsheetGetRowByFilters(ctx, spreadsheetId, sheetName, filters)
+	if err != nil {
+		return 0, err
+	}
 
 	if rowByFilters == nil {
-		id = getUniqueId(rows)
+		rows, err := p.spreadsheetGet(ctx, spreadsheetId, sheetName, "A:A")
+		if err != nil {
+			return 0, err
+		}
+		if id, err = getUniqueId(rows); err != nil {
+			return 0, err
+		}
 	} else {
-		// fmt.Println(rowByFilters)
 		idStr, ok := rowByFilters["id"].(string)
 		if !ok {
-			return 0, fmt.Errorf("unable to convert id to string")
+			return 0, withCode("FAILED_PRECONDITION", fmt.Errorf("unable to convert id to string"))
 		}
 
 		i, err := strconv.ParseInt(idStr, 0, 64)
 		if err != nil {
-			return 0, fmt.Errorf("unable to parse string to int64 for %s: %v", idStr, err)
+			return 0, withCode("FAILED_PRECONDITION", fmt.Errorf("unable to parse string to int64 for %s: %w", idStr, err))
 		}
 		return i, nil
 	}
 
 	values["id"] = id
 
+	sorted, err := sortValuesByHeaders(headers, values)
+	if err != nil {
+		return 0, err
+	}
+
 	row := &sheets.ValueRange{
-		Values: [][]interface{}{sortValuesByHeaders(headers, values)},
+		Values: [][]interface{}{sorted},
 	}
 
-	res, err := g.sheet.Spreadsheets.Values.Append(spreadsheetId, sheetName, row).ValueInputOption("RAW").Context(ctx).Do()
-	if err != nil || res.HTTPStatusCode != 200 {
-		log.Fatalf("unable to append data into sheet %s <%v>.", sheetName, err)
+	call := c.Spreadsheets.Values.Append(spreadsheetId, sheetName, row).ValueInputOption("RAW").Context(ctx)
+	res, err := retry(ctx, func() (*sheets.AppendValuesResponse, error) { return call.Do() })
+	if err == nil && res.HTTPStatusCode != http.StatusOK {
+		err = httpStatusError(res.HTTPStatusCode)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("unable to append data into sheet %s <%w>", sheetName, err)
 	}
 
 	return id, nil
 }
 
 // This function initializes the Google Sheets client.
-func (g *Gcp) sheetClient() {
+func (g *Gcp) sheetClient() (*sheets.Service, error) {
+	g.clientsMu.Lock()
+	defer g.clientsMu.Unlock()
+
 	if g.sheet == nil {
-		ctx := context.Background()
-		jwt, err := getJwtConfig(g.keyByte, g.scope)
+		c, err := g.acquireClient(sheetsService, (*Gcp).newSheetClient)
 		if err != nil {
-			log.Fatalf("could not get JWT config with scope %s <%v>.", g.scope, err)
+			return nil, err
 		}
 
-		c, err := sheets.NewService(ctx, option.WithTokenSource(jwt.TokenSource(ctx)))
-		if err != nil {
-			log.Fatalf("could not initialize Sheets client <%v>.", err)
-		}
+		g.sheet = c.(*sheets.Service)
+	}
+
+	return g.sheet, nil
+}
+
+// This is a method of the `Gcp` struct that creates its Sheets client, bound to a context.
+func (g *Gcp) newSheetClient(ctx context.Context) (interface{}, func() error, error) {
+	auth, err := g.authOptions(sheetsService, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	o := g.serviceClientOptions(sheetsService)
+	options := append(o.options(), auth...)
+
+	hc, err := g.restHttpClient(ctx, options...)
+	if err != nil {
+		return nil, nil, err
+	}
+	hc.Transport = g.authorizeTransport(sheetsService, hc.Transport)
 
-		g.sheet = c
+	c, err := sheets.NewService(ctx, o.restOptions(hc)...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not initialize Sheets client <%w>", err)
 	}
+	c.UserAgent = o.UserAgent
+
+	return c, nil, nil
 }
 
 // This function returns the cell range of the first row of a Google Sheet.
@@ -220,14 +436,14 @@ func (g *Gcp) sheetClient() {
 // - sheetName: the name of the sheet to retrieve data from.
 // Returns:
 // - string: the cell range of the first row.
-func (g *Gcp) findCellRangeAndHeaders(spreadsheetId string, sheetName string) (string, []interface{}, error) {
-	rows, err := g.SpreadsheetGet(spreadsheetId, sheetName, "1:1")
+func (g *Gcp) findCellRangeAndHeaders(ctx context.Context, spreadsheetId string, sheetName string) (string, []interface{}, error) {
+	rows, err := g.spreadsheetGet(ctx, spreadsheetId, sheetName, "1:1")
 	if err != nil {
 		return "", nil, err
 	}
 
 	if len(rows) < 1 {
-		return "", nil, fmt.Errorf("no headers found on sheet %s!%s", spreadsheetId, sheetName)
+		return "", nil, withCode("NOT_FOUND", fmt.Errorf("no headers found on sheet %s!%s", spreadsheetId, sheetName))
 	}
 
 	return fmt.Sprintf("A:%s", columnIndexToLetter(len(rows[0])-1)), rows[0], nil
@@ -2,7 +2,6 @@ package gcp
 
 import (
 	"fmt"
-	"log"
 	"strconv"
 	"strings"
 )
@@ -12,20 +11,21 @@ import (
 // - rows: a slice of slices of interface{} representing the rows of a Google Sheet.
 // Returns:
 // - string: a unique ID for a new row.
-func getUniqueId(rows [][]interface{}) int64 {
+// - error: an error if the ID of the last row is not a number, otherwise nil.
+func getUniqueId(rows [][]interface{}) (int64, error) {
 	var id int64
 
 	if len(rows) > 1 {
-		lastID, err := strconv.ParseInt(rows[len(rows)-1][0].(string), 10, 64)
+		lastID, err := strconv.ParseInt(fmt.Sprint(rows[len(rows)-1][0]), 10, 64)
 		if err != nil {
-			log.Fatalf("unable to parse the last ID from last row as %s <%v>.", rows[len(rows)-1], err)
+			return 0, withCode("FAILED_PRECONDITION", fmt.Errorf("unable to parse the last ID from last row as %s <%w>", rows[len(rows)-1], err))
 		}
 		id = lastID + 1
 	} else {
 		id = 1
 	}
 
-	return id
+	return id, nil
 }
 
 // This function merges two slices of interface{} into a map[string]interface{}.
@@ -33,19 +33,21 @@ func getUniqueId(rows [][]interface{}) int64 {
 // - keys: a slice of interface{} representing the keys of the map.
 // - values: a slice of interface{} representing the values of the map.
 // Returns:
-// - map[string]interface{}: a map with keys and values from the input slices.
+// - map[string]interface{}: a map with keys and values from the input slices. Sheets leaves out the
+// trailing empty cells of a row, so keys without a value are mapped to nil.
 func mergeKV(keys []interface{}, values []interface{}) map[string]interface{} {
-	mergedMap := make(map[string]interface{})
-	// Check if the lengths of keys and values arrays are the same
-	if len(keys) == len(values) {
-		// Iterate over the keys and values arrays
-		for i := 0; i < len(keys); i++ {
-			key := keys[i]
-			value := values[i]
-			mergedMap[key.(string)] = value
+	mergedMap := make(map[string]interface{}, len(keys))
+	for i, key := range keys {
+		name, ok := key.(string)
+		if !ok {
+			continue
 		}
-	} else {
-		fmt.Println("error: length of keys and values arrays must be the same.")
+
+		var value interface{}
+		if i < len(values) {
+			value = values[i]
+		}
+		mergedMap[name] = value
 	}
 
 	return mergedMap
@@ -75,7 +77,7 @@ func columnIndexToLetter(index int) string {
 // - int: the index of the header in the slice, or -1 if not found.
 func findHeaderIndex(headers []interface{}, header string) int {
 	for i, h := range headers {
-		if strings.TrimSpace(h.(string)) == header {
+		if name, ok := h.(string); ok && strings.TrimSpace(name) == header {
 			return i
 		}
 	}
@@ -88,10 +90,13 @@ func findHeaderIndex(headers []interface{}, header string) int {
 // - values: a map[string]interface{} representing the values to sort.
 // Returns:
 // - []interface{}: a slice of interface{} values sorted by the headers.
-func sortValuesByHeaders(headers []interface{}, values map[string]interface{}) []interface{} {
+// - error: an error if a value has no matching column, otherwise nil.
+func sortValuesByHeaders(headers []interface{}, values map[string]interface{}) ([]interface{}, error) {
 	headerMap := make(map[string]int)
 	for i, header := range headers {
-		headerMap[header.(string)] = i
+		if name, ok := header.(string); ok {
+			headerMap[name] = i
+		}
 	}
 
 	// Create the new row data in the correct order
@@ -99,12 +104,12 @@ func sortValuesByHeaders(headers []interface{}, values map[string]interface{}) [
 	for columnName, value := range values {
 		index, found := headerMap[columnName]
 		if !found {
-			log.Fatalf("column '%s' not found in the sheet", columnName)
+			return nil, withCode("INVALID_ARGUMENT", fmt.Errorf("column '%s' not found in the sheet", columnName))
 		}
 		sorted[index] = value
 	}
 
-	return sorted
+	return sorted, nil
 }
 
 // func getSheetName(c *sheets.Service, spreadsheetId string, sheetId int) string {
@@ -0,0 +1,96 @@
+package gcp
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestGetUniqueId(t *testing.T) {
+	tests := []struct {
+		name     string
+		rows     [][]interface{}
+		expected int64
+		code     string
+	}{
+		{"no rows", nil, 1, ""},
+		{"headers only", [][]interface{}{{"id", "name"}}, 1, ""},
+		{"numeric IDs", [][]interface{}{{"id", "name"}, {"1", "a"}, {"41", "b"}}, 42, ""},
+		{"number cell", [][]interface{}{{"id", "name"}, {float64(7), "a"}}, 8, ""},
+		{"non-numeric ID", [][]interface{}{{"id", "name"}, {"abc", "a"}}, 0, "FAILED_PRECONDITION"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := getUniqueId(tt.rows)
+			if tt.code != "" {
+				var ce codedError
+				if !errors.As(err, &ce) || ce.code != tt.code {
+					t.Fatalf("expected error with code %s, got %v", tt.code, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != tt.expected {
+				t.Errorf("expected ID %d, got %d", tt.expected, id)
+			}
+		})
+	}
+}
+
+func TestSortValuesByHeaders(t *testing.T) {
+	headers := []interface{}{"id", "name", "email"}
+
+	tests := []struct {
+		name     string
+		values   map[string]interface{}
+		expected []interface{}
+		code     string
+	}{
+		{"every column", map[string]interface{}{"email": "a@b.c", "id": 1, "name": "a"}, []interface{}{1, "a", "a@b.c"}, ""},
+		{"missing columns", map[string]interface{}{"name": "a"}, []interface{}{nil, "a", nil}, ""},
+		{"unknown column", map[string]interface{}{"phone": "123"}, nil, "INVALID_ARGUMENT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted, err := sortValuesByHeaders(headers, tt.values)
+			if tt.code != "" {
+				var ce codedError
+				if !errors.As(err, &ce) || ce.code != tt.code {
+					t.Fatalf("expected error with code %s, got %v", tt.code, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(sorted, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, sorted)
+			}
+		})
+	}
+}
+
+func TestMergeKV(t *testing.T) {
+	tests := []struct {
+		name     string
+		keys     []interface{}
+		values   []interface{}
+		expected map[string]interface{}
+	}{
+		{"every column", []interface{}{"id", "name"}, []interface{}{"1", "a"}, map[string]interface{}{"id": "1", "name": "a"}},
+		{"trailing empty cells", []interface{}{"id", "name", "email"}, []interface{}{"1"}, map[string]interface{}{"id": "1", "name": nil, "email": nil}},
+		{"numeric header", []interface{}{"id", float64(2024)}, []interface{}{"1", "a"}, map[string]interface{}{"id": "1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if m := mergeKV(tt.keys, tt.values); !reflect.DeepEqual(m, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, m)
+			}
+		})
+	}
+}
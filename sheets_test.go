@@ -0,0 +1,87 @@
+package gcp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSpreadsheetAppendWithUniqueId(t *testing.T) {
+	tests := []struct {
+		name     string
+		ids      func(w http.ResponseWriter)
+		expected int64
+		code     string
+	}{
+		{
+			name:     "next ID",
+			ids:      func(w http.ResponseWriter) { writeValues(t, w, [][]interface{}{{"id"}, {"1"}, {"2"}}) },
+			expected: 3,
+		},
+		{
+			name: "throttled ID read",
+			ids:  func(w http.ResponseWriter) { w.WriteHeader(http.StatusTooManyRequests) },
+			code: "RESOURCE_EXHAUSTED",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var appended [][]interface{}
+			sheets := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/v4/spreadsheets/sheet-id/values/Users!1:1":
+					writeValues(t, w, [][]interface{}{{"id", "name"}})
+				case "/v4/spreadsheets/sheet-id/values/Users!A:A":
+					tt.ids(w)
+				case "/v4/spreadsheets/sheet-id/values/Users:append":
+					var body struct {
+						Values [][]interface{} `json:"values"`
+					}
+					if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+						t.Error(err)
+					}
+					appended = append(appended, body.Values...)
+					w.Header().Set("Content-Type", "application/json")
+					_, _ = w.Write([]byte(`{}`))
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			t.Cleanup(sheets.Close)
+
+			tokens, _ := tokenServer(t)
+			g := newTestGcp(t,
+				withGcpConstructorKey(testServiceAccountKey(t, tokens.URL), ""),
+				withGcpConstructorClientOptions(GcpClientOptions{Services: map[string]GcpClientOptions{sheetsService: {Endpoint: sheets.URL + "/"}}}),
+			)
+
+			id, err := g.SpreadsheetAppendWithUniqueId("sheet-id", "Users", map[string]interface{}{"name": "a"}, CallOptions{})
+			if tt.code != "" {
+				var ge *GcpError
+				if !errors.As(err, &ge) || ge.Code != tt.code {
+					t.Fatalf("expected error with code %s, got %v", tt.code, err)
+				}
+				if len(appended) != 0 {
+					t.Errorf("expected no row to be appended, got %v", appended)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if id != tt.expected || len(appended) != 1 || appended[0][0] != float64(tt.expected) || appended[0][1] != "a" {
+				t.Errorf("expected a row with ID %d, got ID %d and rows %v", tt.expected, id, appended)
+			}
+		})
+	}
+}
+
+func writeValues(t *testing.T, w http.ResponseWriter, values [][]interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]interface{}{"values": values}); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,283 @@
+package gcp
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	"go.k6.io/k6/js/common"
+	iamcredentials "google.golang.org/api/iamcredentials/v1"
+)
+
+// Lifetime of signed JWTs without an `expires_in` option or `exp` claim
+const defaultJwtLifetime = time.Hour
+
+// Options of `SignJwt`
+type SignJwtOptions struct {
+	// Sets the `aud` claim
+	Audience string
+	// Lifetime of the token as a duration, e.g. `30m`, used to set the `exp` claim
+	ExpiresIn string
+	// Additional JOSE header fields, only supported when signing locally
+	Header map[string]interface{}
+	// Credential profile signing the token
+	Profile string
+	// Maximum time to wait for the signature, e.g. `10s`
+	Timeout string
+}
+
+// This is a method of the `Gcp` struct that signs arbitrary claims as a JWT on behalf of the service
+// account. The `iss` and `sub` claims default to the service account email, `iat` to now and `exp` to
+// one hour later. Service account keys sign locally with RS256, keyless credentials sign through the
+// IAM Credentials signJwt endpoint.
+func (g *Gcp) SignJwt(claims map[string]interface{}, options SignJwtOptions) (string, error) {
+	ctx, cancel, err := g.callContext(options.Timeout)
+	if err != nil {
+		return "", err
+	}
+	defer cancel()
+
+	p, err := g.resolve(options.Profile)
+	if err != nil {
+		return "", err
+	}
+
+	lifetime := defaultJwtLifetime
+	if options.ExpiresIn != "" {
+		if lifetime, err = time.ParseDuration(options.ExpiresIn); err != nil {
+			return "", fmt.Errorf("invalid expires_in %s <%w>", options.ExpiresIn, err)
+		}
+	}
+
+	c := make(map[string]interface{}, len(claims)+2)
+	for k, v := range claims {
+		c[k] = v
+	}
+	if options.Audience != "" {
+		c["aud"] = options.Audience
+	}
+	if _, ok := c["iat"]; !ok {
+		c["iat"] = time.Now().Unix()
+	}
+	if _, ok := c["exp"]; !ok {
+		c["exp"] = time.Now().Add(lifetime).Unix()
+	}
+
+	return p.signJwt(ctx, c, options.Header)
+}
+
+// This is a method of the `Gcp` struct that signs bytes with the service account key, using RSA SHA-256
+// locally or the IAM Credentials signBlob endpoint for keyless credentials. It returns the base64
+// encoded signature.
+func (g *Gcp) SignBlob(data interface{}, opts CallOptions) (string, error) {
+	ctx, cancel, err := g.callContext(opts.Timeout)
+	if err != nil {
+		return "", err
+	}
+	defer cancel()
+
+	p, err := g.resolve(opts.Profile)
+	if err != nil {
+		return "", err
+	}
+
+	b, err := common.ToBytes(data)
+	if err != nil {
+		return "", fmt.Errorf("unsupported data to sign <%w>", err)
+	}
+
+	if key, ok := p.localSigningKey(); ok {
+		signature, err := signRS256(key, b)
+		if err != nil {
+			return "", err
+		}
+
+		return base64.StdEncoding.EncodeToString(signature), nil
+	}
+
+	s, email, err := p.iamSigner()
+	if err != nil {
+		return "", err
+	}
+
+	name := serviceAccountResourceName(email)
+	req := &iamcredentials.SignBlobRequest{
+		Delegates: p.delegateResourceNames(),
+		Payload:   base64.StdEncoding.EncodeToString(b),
+	}
+
+	res, err := s.Projects.ServiceAccounts.SignBlob(name, req).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("unable to sign blob as %s <%w>", name, err)
+	}
+
+	return res.SignedBlob, nil
+}
+
+// This is a method of the `Gcp` struct that signs claims as a JWT. The `iss` and `sub` claims default to
+// the email of the signing service account.
+func (g *Gcp) signJwt(ctx context.Context, claims map[string]interface{}, header map[string]interface{}) (string, error) {
+	if key, ok := g.localSigningKey(); ok {
+		sa := g.key.(*ServiceAccountKey)
+		setDefaultClaim(claims, "iss", sa.ClientEmail)
+		setDefaultClaim(claims, "sub", sa.ClientEmail)
+
+		h := map[string]interface{}{
+			"alg": "RS256",
+			"typ": "JWT",
+			"kid": sa.PrivateKeyID,
+		}
+		for k, v := range header {
+			h[k] = v
+		}
+
+		return signJwtRS256(key, h, claims)
+	}
+
+	if len(header) != 0 {
+		return "", fmt.Errorf("custom JWT headers cannot be set when signing through IAM Credentials")
+	}
+
+	s, email, err := g.iamSigner()
+	if err != nil {
+		return "", err
+	}
+
+	name := serviceAccountResourceName(email)
+	setDefaultClaim(claims, "iss", email)
+	setDefaultClaim(claims, "sub", email)
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JWT claims <%w>", err)
+	}
+
+	req := &iamcredentials.SignJwtRequest{
+		Delegates: g.delegateResourceNames(),
+		Payload:   string(payload),
+	}
+
+	res, err := s.Projects.ServiceAccounts.SignJwt(name, req).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("unable to sign JWT as %s <%w>", name, err)
+	}
+
+	return res.SignedJwt, nil
+}
+
+// This is a method of the `Gcp` struct that returns the parsed private key of a service account key.
+// Impersonation takes precedence, since tokens are then signed on behalf of the impersonated account.
+func (g *Gcp) localSigningKey() (*rsa.PrivateKey, bool) {
+	if g.impersonateServiceAccount != "" {
+		return nil, false
+	}
+
+	sa, ok := g.key.(*ServiceAccountKey)
+	if !ok {
+		return nil, false
+	}
+
+	g.privateKeyOnce.Do(func() {
+		g.privateKey, g.privateKeyErr = parsePrivateKey(sa.PrivateKey)
+	})
+
+	return g.privateKey, g.privateKeyErr == nil
+}
+
+// This is a method of the `Gcp` struct that returns the IAM Credentials client and the email of the
+// service account signing on behalf of keyless credentials: the impersonated service account or
+// the default service account of the metadata server.
+func (g *Gcp) iamSigner() (*iamcredentials.Service, string, error) {
+	if g.privateKeyErr != nil {
+		return nil, "", fmt.Errorf("unable to parse private key of service account <%w>", g.privateKeyErr)
+	}
+
+	var email string
+	switch {
+	case g.impersonateServiceAccount != "":
+		email = g.impersonateServiceAccount
+	case g.credentials != nil && g.credentials.JSON == nil:
+		var err error
+		if email, err = metadata.Email("default"); err != nil {
+			return nil, "", fmt.Errorf("unable to get service account email from metadata server <%w>", err)
+		}
+	default:
+		return nil, "", fmt.Errorf("signing requires a service account key, an impersonated service account or the metadata server")
+	}
+
+	s, err := g.iamCredentialsClient()
+	if err != nil {
+		return nil, "", err
+	}
+
+	return s, email, nil
+}
+
+func setDefaultClaim(claims map[string]interface{}, name string, value string) {
+	if _, ok := claims[name]; !ok {
+		claims[name] = value
+	}
+}
+
+// The function parses a PEM encoded RSA private key in PKCS#8 or PKCS#1 form.
+func parsePrivateKey(privateKey string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return nil, fmt.Errorf("private key is not PEM encoded")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse private key <%w>", err)
+	}
+
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+
+	return key, nil
+}
+
+func signRS256(key *rsa.PrivateKey, data []byte) ([]byte, error) {
+	h := sha256.Sum256(data)
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign data <%w>", err)
+	}
+
+	return signature, nil
+}
+
+func signJwtRS256(key *rsa.PrivateKey, header map[string]interface{}, claims map[string]interface{}) (string, error) {
+	h, err := json.Marshal(header)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JWT header <%w>", err)
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal JWT claims <%w>", err)
+	}
+
+	unsigned := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	signature, err := signRS256(key, []byte(unsigned))
+	if err != nil {
+		return "", err
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
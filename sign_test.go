@@ -0,0 +1,142 @@
+package gcp
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignLocally(t *testing.T) {
+	tokens, _ := tokenServer(t)
+	key := testServiceAccountKey(t, tokens.URL)
+	g := newTestGcp(t, withGcpConstructorKey(key, ""))
+
+	private, err := parsePrivateKey(key["private_key"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := func(data string, signature []byte) {
+		t.Helper()
+
+		h := sha256.Sum256([]byte(data))
+		if err := rsa.VerifyPKCS1v15(&private.PublicKey, crypto.SHA256, h[:], signature); err != nil {
+			t.Errorf("expected a signature of the service account key <%v>", err)
+		}
+	}
+
+	token, err := g.SignJwt(map[string]interface{}{"tenant": "a"}, SignJwtOptions{Audience: "https://api.example.com", ExpiresIn: "30m", Header: map[string]interface{}{"x5u": "https://example.com/certs"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("expected a JWT, got %s", token)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify(parts[0]+"."+parts[1], signature)
+
+	d, err := decodeJwt(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedHeader := map[string]interface{}{"alg": "RS256", "typ": "JWT", "kid": "key-1", "x5u": "https://example.com/certs"}
+	if !reflect.DeepEqual(d.Header, expectedHeader) {
+		t.Errorf("expected header %v, got %v", expectedHeader, d.Header)
+	}
+	email := key["client_email"]
+	if d.Payload["iss"] != email || d.Payload["sub"] != email || d.Payload["aud"] != "https://api.example.com" || d.Payload["tenant"] != "a" {
+		t.Errorf("unexpected claims %v", d.Payload)
+	}
+	if exp := time.Unix(int64(d.Payload["exp"].(float64)), 0); math.Abs(time.Until(exp).Minutes()-30) > 1 {
+		t.Errorf("expected the token to expire in 30 minutes, got %s", exp)
+	}
+
+	blob, err := g.SignBlob("data", CallOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature, err = base64.StdEncoding.DecodeString(blob); err != nil {
+		t.Fatal(err)
+	}
+	verify("data", signature)
+}
+
+func TestSignThroughIamCredentials(t *testing.T) {
+	const (
+		target   = "target@p.iam.gserviceaccount.com"
+		delegate = "delegate@p.iam.gserviceaccount.com"
+	)
+
+	type request struct {
+		Delegates []string `json:"delegates"`
+		Payload   string   `json:"payload"`
+	}
+	requests := make(map[string]request)
+	iam := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body request
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		requests[strings.TrimPrefix(r.URL.Path, "/v1/projects/-/serviceAccounts/"+target+":")] = body
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/projects/-/serviceAccounts/" + target + ":signJwt":
+			_ = json.NewEncoder(w).Encode(map[string]string{"keyId": "iam-key", "signedJwt": "signed-jwt"})
+		case "/v1/projects/-/serviceAccounts/" + target + ":signBlob":
+			_ = json.NewEncoder(w).Encode(map[string]string{"keyId": "iam-key", "signedBlob": "c2lnbmF0dXJl"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(iam.Close)
+
+	tokens, _ := tokenServer(t)
+	g := newTestGcp(t,
+		withGcpConstructorKey(testServiceAccountKey(t, tokens.URL), ""),
+		withGcpConstructorImpersonation(target, []string{delegate}),
+		withGcpConstructorClientOptions(GcpClientOptions{Services: map[string]GcpClientOptions{iamCredentialsService: {Endpoint: iam.URL + "/"}}}),
+	)
+
+	if _, err := g.SignJwt(nil, SignJwtOptions{Header: map[string]interface{}{"x5u": "https://example.com/certs"}}); err == nil {
+		t.Error("expected custom headers to be rejected when signing through IAM Credentials")
+	}
+
+	token, err := g.SignJwt(map[string]interface{}{"iat": 1, "exp": 2}, SignJwtOptions{Audience: "https://api.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := g.SignBlob("data", CallOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "signed-jwt" || blob != "c2lnbmF0dXJl" {
+		t.Errorf("expected the signatures of IAM Credentials, got %s and %s", token, blob)
+	}
+
+	delegates := []string{"projects/-/serviceAccounts/" + delegate}
+	claims, err := json.Marshal(map[string]interface{}{"aud": "https://api.example.com", "iss": target, "sub": target, "iat": 1, "exp": 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]request{
+		"signJwt":  {Delegates: delegates, Payload: string(claims)},
+		"signBlob": {Delegates: delegates, Payload: base64.StdEncoding.EncodeToString([]byte("data"))},
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("expected requests %+v, got %+v", expected, requests)
+	}
+}
@@ -0,0 +1,134 @@
+package gcp
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// Cached tokens are refreshed this long before they expire
+const tokenEarlyExpiry = time.Minute
+
+type (
+	// Process-wide token cache shared by the `Gcp` instances of every VU. Entries are keyed by the
+	// credential identity plus the scope or audience of the token.
+	tokenCache struct {
+		mu      sync.Mutex
+		entries map[string]*cachedToken
+	}
+
+	// The entry lock is held while a token is fetched, so concurrent refreshes of the same entry wait
+	// for the one in flight instead of hitting the token endpoint again.
+	cachedToken struct {
+		mu    sync.Mutex
+		token *oauth2.Token
+	}
+
+	// Token source backed by the cache. `fetch` mints a new token when the cached one nears expiry and
+	// `report` is told whether the cache was hit.
+	cachedTokenSource struct {
+		cache  *tokenCache
+		key    string
+		fetch  func() (*oauth2.Token, error)
+		report func(hit bool)
+	}
+
+	// Registry of the token sources of a `Gcp` instance. Each distinct scope set or audience gets its own
+	// token source, created once. Token sources keep no token of their own: every lookup goes through the
+	// process-wide cache, so that each one is reported as a cache hit or miss.
+	tokenSourceRegistry struct {
+		mu      sync.Mutex
+		sources map[string]oauth2.TokenSource
+	}
+)
+
+func newTokenSourceRegistry() *tokenSourceRegistry {
+	return &tokenSourceRegistry{
+		sources: make(map[string]oauth2.TokenSource),
+	}
+}
+
+// The function returns the token source registered under a key, creating it on first use.
+func (r *tokenSourceRegistry) get(key string, create func() oauth2.TokenSource) oauth2.TokenSource {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ts, ok := r.sources[key]
+	if !ok {
+		ts = create()
+		r.sources[key] = ts
+	}
+
+	return ts
+}
+
+// The function returns a key identifying a set of scopes regardless of their order or duplicates.
+func scopeKey(scope []string) string {
+	sorted := make([]string, 0, len(scope))
+	seen := make(map[string]bool)
+	for _, s := range scope {
+		if !seen[s] {
+			seen[s] = true
+			sorted = append(sorted, s)
+		}
+	}
+	sort.Strings(sorted)
+
+	return strings.Join(sorted, " ")
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{
+		entries: make(map[string]*cachedToken),
+	}
+}
+
+func (c *tokenCache) entry(key string) *cachedToken {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		e = &cachedToken{}
+		c.entries[key] = e
+	}
+
+	return e
+}
+
+func (s cachedTokenSource) Token() (*oauth2.Token, error) {
+	e := s.cache.entry(s.key)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.token != nil && (e.token.Expiry.IsZero() || time.Until(e.token.Expiry) > tokenEarlyExpiry) {
+		s.report(true)
+		return e.token, nil
+	}
+
+	s.report(false)
+	token, err := s.fetch()
+	if err != nil {
+		return nil, err
+	}
+	e.token = token
+
+	return token, nil
+}
+
+// The function drops the cached token of a key if it is still the given one. A token already refreshed by
+// another VU is kept.
+func (c *tokenCache) invalidate(key string, accessToken string) {
+	e := c.entry(key)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.token != nil && e.token.AccessToken == accessToken {
+		e.token = nil
+	}
+}
@@ -0,0 +1,144 @@
+package gcp
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestScopeKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		scope    []string
+		expected string
+	}{
+		{"empty", nil, ""},
+		{"single", []string{"a"}, "a"},
+		{"sorted", []string{"b", "a", "c"}, "a b c"},
+		{"duplicates", []string{"b", "a", "b", "a"}, "a b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if k := scopeKey(tt.scope); k != tt.expected {
+				t.Errorf("scopeKey(%q) = %q, expected %q", tt.scope, k, tt.expected)
+			}
+		})
+	}
+}
+
+func TestCachedTokenSourceSharesFetches(t *testing.T) {
+	var fetches atomic.Int32
+	var hits atomic.Int32
+	ts := cachedTokenSource{
+		cache: newTokenCache(),
+		key:   "identity|scope:a",
+		fetch: func() (*oauth2.Token, error) {
+			fetches.Add(1)
+			// Concurrent callers pile up while the token is fetched
+			time.Sleep(50 * time.Millisecond)
+			return &oauth2.Token{AccessToken: "token", Expiry: time.Now().Add(time.Hour)}, nil
+		},
+		report: func(hit bool) {
+			if hit {
+				hits.Add(1)
+			}
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			token, err := ts.Token()
+			if err != nil || token.AccessToken != "token" {
+				t.Errorf("expected the cached token, got %v %v", token, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("expected concurrent callers to share one fetch, got %d fetches", n)
+	}
+	if n := hits.Load(); n != 19 {
+		t.Errorf("expected every caller but the first to hit the cache, got %d hits", n)
+	}
+}
+
+func TestCachedTokenSourceRefreshesBeforeExpiry(t *testing.T) {
+	tests := []struct {
+		name    string
+		expiry  time.Duration
+		fetches int32
+	}{
+		{"valid token", 2 * tokenEarlyExpiry, 1},
+		{"token nearing expiry", tokenEarlyExpiry / 2, 2},
+		{"expired token", -time.Minute, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var fetches atomic.Int32
+			ts := cachedTokenSource{
+				cache: newTokenCache(),
+				key:   "identity|scope:a",
+				fetch: func() (*oauth2.Token, error) {
+					n := fetches.Add(1)
+					return &oauth2.Token{AccessToken: fmt.Sprintf("token-%d", n), Expiry: time.Now().Add(tt.expiry)}, nil
+				},
+				report: func(bool) {},
+			}
+
+			if _, err := ts.Token(); err != nil {
+				t.Fatal(err)
+			}
+			token, err := ts.Token()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if n := fetches.Load(); n != tt.fetches {
+				t.Errorf("expected %d fetches, got %d", tt.fetches, n)
+			}
+			if expected := fmt.Sprintf("token-%d", tt.fetches); token.AccessToken != expected {
+				t.Errorf("expected token %s, got %s", expected, token.AccessToken)
+			}
+		})
+	}
+}
+
+func TestTokenSourceReportsEveryLookup(t *testing.T) {
+	tokens, minted := tokenServer(t)
+	mi, samples := newTestModuleInstance(t)
+	g, err := mi.gcpFromConfig(GcpConfig{Key: testServiceAccountKey(t, tokens.URL)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, err := g.GetOAuth2AccessToken(nil, CallOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if n := minted.Load(); n != 1 {
+		t.Errorf("expected one token to be minted, got %d", n)
+	}
+
+	counts := make(map[string]int)
+	for _, s := range collectSamples(samples) {
+		if tokenType, _ := s.Tags.Get("token_type"); tokenType == "access_token" {
+			counts[s.Metric.Name]++
+		}
+	}
+	if counts["gcp_token_cache_misses"] != 1 || counts["gcp_token_cache_hits"] != 2 {
+		t.Errorf("expected a miss followed by 2 hits, got %v", counts)
+	}
+}
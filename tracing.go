@@ -0,0 +1,279 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+const (
+	tracerName = "github.com/deejiw/xk6-gcp"
+	// Service name of the spans exported to the collector
+	defaultTracingServiceName = "k6"
+	tracingProtocolHttp       = "http"
+	tracingProtocolGrpc       = "grpc"
+	// Maximum time to flush the spans once the test ends
+	tracingShutdownTimeout = 10 * time.Second
+)
+
+// W3C trace context propagated into PubSub message attributes
+var traceContextPropagator = propagation.TraceContext{}
+
+type (
+	// OTLP exporter of the spans of the module. Without an endpoint, spans go to the traces output of k6,
+	// if any.
+	GcpTracingOptions struct {
+		// Endpoint of the OTLP collector, e.g. `localhost:4318`
+		Endpoint string
+		// `http` (default) or `grpc`
+		Protocol string
+		// Whether to connect to the collector without TLS
+		Insecure bool
+		// Headers of the export requests, e.g. for authentication
+		Headers map[string]string
+		// Service name of the spans, defaults to `k6`
+		ServiceName string
+	}
+
+	// Span of the current iteration of a VU, parent of the spans of the operations made during the
+	// iteration, so that a single iteration can be followed in the tracing backend. It is shared by every
+	// `Gcp` instance of the VU and ends with the last operation of the iteration.
+	iterationTrace struct {
+		mu        sync.Mutex
+		iteration int64
+		span      trace.Span
+		last      time.Time
+	}
+)
+
+func withGcpConstructorTracing(options GcpTracingOptions) func(*Gcp) error {
+	return func(g *Gcp) error {
+		switch options.Protocol {
+		case "":
+			options.Protocol = tracingProtocolHttp
+		case tracingProtocolHttp, tracingProtocolGrpc:
+		default:
+			return fmt.Errorf("unknown tracing protocol %s, expected %s or %s", options.Protocol, tracingProtocolHttp, tracingProtocolGrpc)
+		}
+
+		if options.ServiceName == "" {
+			options.ServiceName = defaultTracingServiceName
+		}
+		g.tracing = options
+
+		return nil
+	}
+}
+
+// This is a method of the `Gcp` struct that returns the tracer of the operations: the one of the OTLP
+// exporter when configured, otherwise the one of the traces output of k6, which does not record spans
+// unless k6 runs with `--traces-output`.
+func (g *Gcp) tracer() trace.Tracer {
+	if g.tracing.Endpoint == "" {
+		if g.vu != nil {
+			if state := g.vu.State(); state != nil && state.TracerProvider != nil {
+				return state.TracerProvider.Tracer(tracerName)
+			}
+		}
+
+		return noop.NewTracerProvider().Tracer(tracerName)
+	}
+
+	g.tracingMu.Lock()
+	defer g.tracingMu.Unlock()
+
+	if g.tracerProvider == nil {
+		if g.lifetime.Err() != nil {
+			return noop.NewTracerProvider().Tracer(tracerName)
+		}
+
+		// The exporter is shared by every VU with the same configuration
+		key := fmt.Sprintf("tracing|%+v", g.tracing)
+		tp, err := g.clients.acquire(key, g.newTracerProvider)
+		if err != nil {
+			// Failing to trace never fails the operations
+			if state := g.vu.State(); state != nil {
+				state.Logger.WithError(err).Warn("unable to export spans of the gcp module")
+			}
+
+			return noop.NewTracerProvider().Tracer(tracerName)
+		}
+
+		g.tracerProvider = tp.(*sdktrace.TracerProvider)
+		g.tracerRelease = func() error {
+			return g.clients.release(key)
+		}
+	}
+
+	return g.tracerProvider.Tracer(tracerName)
+}
+
+// This is a method of the `Gcp` struct that creates the tracer provider exporting spans to the OTLP
+// collector. It is shut down, flushing the remaining spans, once the last VU using it ended.
+func (g *Gcp) newTracerProvider(ctx context.Context) (interface{}, func() error, error) {
+	var exporter *otlptrace.Exporter
+	var err error
+
+	if g.tracing.Protocol == tracingProtocolGrpc {
+		options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(g.tracing.Endpoint), otlptracegrpc.WithHeaders(g.tracing.Headers)}
+		if g.tracing.Insecure {
+			options = append(options, otlptracegrpc.WithInsecure())
+		}
+		exporter, err = otlptracegrpc.New(ctx, options...)
+	} else {
+		options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(g.tracing.Endpoint), otlptracehttp.WithHeaders(g.tracing.Headers)}
+		if g.tracing.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, options...)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not initialize OTLP exporter of %s <%w>", g.tracing.Endpoint, err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", g.tracing.ServiceName))),
+	)
+
+	shutdown := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+
+		return tp.Shutdown(ctx)
+	}
+
+	return tp, shutdown, nil
+}
+
+// The function starts the span of the request, as a child of the span of the context or else of the span
+// of the current iteration. The span of the VU context, the trace of k6, is the parent of the iteration.
+func (r *gcpRequest) startSpan(ctx context.Context) context.Context {
+	tracer := r.g.tracer()
+	if sc := trace.SpanContextFromContext(ctx); !sc.IsValid() || sc.Equal(trace.SpanContextFromContext(r.g.vuContext())) {
+		ctx = r.g.iterations.context(ctx, r.g, tracer, r.start)
+	}
+
+	attributes := []attribute.KeyValue{
+		attribute.String("gcp.service", r.service),
+		attribute.String("gcp.method", r.method),
+	}
+	if r.resource != "" {
+		attributes = append(attributes, attribute.String("gcp.resource", r.resource))
+	}
+
+	ctx, r.span = tracer.Start(ctx, r.service+"."+r.method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithTimestamp(r.start),
+		trace.WithAttributes(attributes...),
+	)
+
+	return ctx
+}
+
+// The function ends the span of the request with the error it ended with.
+func (r *gcpRequest) endSpan(err error) {
+	if r.span == nil {
+		return
+	}
+
+	code := errorCode(err)
+	r.span.SetAttributes(attribute.String("gcp.status_code", code))
+	if err != nil {
+		r.span.RecordError(err)
+		r.span.SetStatus(codes.Error, code)
+	}
+
+	end := time.Now()
+	r.span.End(trace.WithTimestamp(end))
+	r.g.iterations.done(end)
+}
+
+// The function returns the context holding the span of the current iteration of the VU, starting it when
+// the iteration changed.
+func (t *iterationTrace) context(ctx context.Context, g *Gcp, tracer trace.Tracer, start time.Time) context.Context {
+	if g.vu == nil || g.vu.State() == nil {
+		return ctx
+	}
+	state := g.vu.State()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.span == nil || t.iteration != state.Iteration {
+		t.endLocked()
+
+		attributes := []attribute.KeyValue{
+			attribute.String("k6.vu", strconv.FormatUint(state.VUIDGlobal, 10)),
+			attribute.Int64("k6.iteration", state.Iteration),
+		}
+		if scenario, ok := state.Tags.GetCurrentValues().Tags.Get("scenario"); ok {
+			attributes = append(attributes, attribute.String("k6.scenario", scenario))
+		}
+
+		// The iteration span continues the trace of k6 when the VU context holds one, and is otherwise a root
+		// span. It must not end with the context of the operation.
+		parent := context.Background()
+		if sc := trace.SpanContextFromContext(g.vuContext()); sc.IsValid() {
+			parent = trace.ContextWithSpanContext(parent, sc)
+		}
+		_, t.span = tracer.Start(parent, "k6.iteration",
+			trace.WithTimestamp(start),
+			trace.WithAttributes(attributes...),
+		)
+		t.iteration = state.Iteration
+		t.last = start
+	}
+
+	return trace.ContextWithSpan(ctx, t.span)
+}
+
+// The function records the end of an operation of the iteration.
+func (t *iterationTrace) done(end time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if end.After(t.last) {
+		t.last = end
+	}
+}
+
+// The function ends the span of the current iteration, e.g. once the VU ended.
+func (t *iterationTrace) end() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.endLocked()
+}
+
+func (t *iterationTrace) endLocked() {
+	if t.span != nil {
+		t.span.End(trace.WithTimestamp(t.last))
+		t.span = nil
+	}
+}
+
+// The function injects the W3C trace context of the context into the attributes of a PubSub message, so
+// that consumers continue the trace of the publishing iteration.
+func traceContextAttributes(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	traceContextPropagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+
+	return carrier
+}
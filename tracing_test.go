@@ -0,0 +1,116 @@
+package gcp
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	pb "cloud.google.com/go/pubsub/apiv1/pubsubpb"
+	"cloud.google.com/go/pubsub/pstest"
+	"go.k6.io/k6/js/modulestest"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestPubsubPublishSpans(t *testing.T) {
+	tests := []struct {
+		name    string
+		k6Trace bool
+	}{
+		{"iteration traced by k6", true},
+		{"untraced iteration", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := pstest.NewServer()
+			t.Cleanup(func() { server.Close() })
+			if _, err := server.GServer.CreateTopic(context.Background(), &pb.Topic{Name: "projects/p/topics/orders"}); err != nil {
+				t.Fatal(err)
+			}
+
+			exporter := tracetest.NewInMemoryExporter()
+			tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+			t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+
+			mi, _ := newTestModuleInstance(t)
+			state := mi.vu.State()
+			state.TracerProvider = tp
+			state.VUIDGlobal = 7
+			state.Iteration = 3
+
+			var k6Span trace.SpanContext
+			if tt.k6Trace {
+				vu := mi.vu.(*modulestest.VU)
+				_, span := tp.Tracer("k6").Start(context.Background(), "iteration")
+				k6Span = span.SpanContext()
+				vu.CtxField = trace.ContextWithSpanContext(vu.CtxField, k6Span)
+			}
+
+			g, err := mi.gcpFromConfig(GcpConfig{Emulators: map[string]string{emulatorPubsub: server.Addr}, ProjectId: "p"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			topic, err := g.PubsubTopic("orders", CallOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := g.PubsubPublish(topic, map[string]interface{}{"id": 1}, CallOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			g.iterations.end()
+
+			spans := make(map[string]tracetest.SpanStub)
+			for _, s := range exporter.GetSpans() {
+				spans[s.Name] = s
+			}
+			iteration, publish := spans["k6.iteration"], spans["pubsub.pubsubPublish"]
+
+			if !iteration.Parent.Equal(k6Span) {
+				t.Errorf("expected the iteration span to have parent %v, got %v", k6Span, iteration.Parent)
+			}
+			if publish.Parent.SpanID() != iteration.SpanContext.SpanID() || publish.SpanContext.TraceID() != iteration.SpanContext.TraceID() {
+				t.Errorf("expected the publish span to be a child of the iteration span, got parent %v", publish.Parent)
+			}
+			if tt.k6Trace && iteration.SpanContext.TraceID() != k6Span.TraceID() {
+				t.Errorf("expected the iteration to continue the trace of k6 %s, got %s", k6Span.TraceID(), iteration.SpanContext.TraceID())
+			}
+
+			expectAttributes(t, iteration.Attributes, map[attribute.Key]attribute.Value{
+				"k6.vu":        attribute.StringValue("7"),
+				"k6.iteration": attribute.Int64Value(3),
+			})
+			expectAttributes(t, publish.Attributes, map[attribute.Key]attribute.Value{
+				"gcp.service":     attribute.StringValue(pubsubService),
+				"gcp.method":      attribute.StringValue("pubsubPublish"),
+				"gcp.resource":    attribute.StringValue("orders"),
+				"gcp.status_code": attribute.StringValue("OK"),
+			})
+
+			messages := server.Messages()
+			if len(messages) != 1 {
+				t.Fatalf("expected one published message, got %d", len(messages))
+			}
+			sc := publish.SpanContext
+			if expected := fmt.Sprintf("00-%s-%s-01", sc.TraceID(), sc.SpanID()); messages[0].Attributes["traceparent"] != expected {
+				t.Errorf("expected traceparent %s, got %s", expected, messages[0].Attributes["traceparent"])
+			}
+		})
+	}
+}
+
+func expectAttributes(t *testing.T, attributes []attribute.KeyValue, expected map[attribute.Key]attribute.Value) {
+	t.Helper()
+
+	actual := make(map[attribute.Key]attribute.Value, len(attributes))
+	for _, a := range attributes {
+		actual[a.Key] = a.Value
+	}
+	for k, v := range expected {
+		if actual[k] != v {
+			t.Errorf("expected attribute %s to be %s, got %s", k, v.Emit(), actual[k].Emit())
+		}
+	}
+}